@@ -3,6 +3,7 @@ package main
 import (
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"syscall"
 
 	"github.com/seandisero/httpfromtcp/internal/headers"
+	"github.com/seandisero/httpfromtcp/internal/negotiate"
 	"github.com/seandisero/httpfromtcp/internal/request"
 	"github.com/seandisero/httpfromtcp/internal/response"
 	"github.com/seandisero/httpfromtcp/internal/server"
@@ -18,29 +20,6 @@ import (
 
 const port = 42069
 
-const badReq = `
-<html>
-  <head>
-    <title>400 Bad Request</title>
-  </head>
-  <body>
-    <h1>Bad Request</h1>
-    <p>Your request honestly kinda sucked.</p>
-  </body>
-</html>`
-
-const svrErr = `
-<html>
-  <head>
-    <title>500 Internal Server Error</title>
-  </head>
-  <body>
-    <h1>Internal Server Error</h1>
-    <p>Okay, you know what? This one is on me.</p>
-  </body>
-</html>
-	`
-
 const isOk = `
 <html>
   <head>
@@ -53,6 +32,8 @@ const isOk = `
 </html>
 	`
 
+const isOkJSON = `{"status":200,"message":"Your request was an absolute banger."}`
+
 func toStr(hash []byte) string {
 	out := ""
 	for _, b := range hash {
@@ -62,55 +43,55 @@ func toStr(hash []byte) string {
 }
 
 func main() {
-	server, err := server.Serve(port, func(w *response.Writer, req *request.Request) {
+	server, err := server.Serve(port, server.HandleErrors(func(w *response.Writer, req *request.Request) error {
 		h := response.GetDefaultHeaders(0)
 		h.Replace("Content-Type", "text/html")
-		requestTarget := req.RequestLine.RequestTarget
-		if requestTarget == "/yourproblem" {
-			w.WriteStatusLine(response.StatusBadRequest)
-
-			body := []byte(badReq)
-			h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
-			w.WriteHeaders(h)
-			w.WriteBody(body)
-		} else if requestTarget == "/myproblem" {
-			w.WriteStatusLine(response.StatusInternalServerError)
-
-			body := []byte(svrErr)
-			h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
-			w.WriteHeaders(h)
-			w.WriteBody(body)
-		} else if strings.HasPrefix(requestTarget, "/httpbin/") {
-			chunkNumber := requestTarget[len("/httpbin/"):]
+		path := req.Path()
+		if path == "/yourproblem" {
+			return &server.HandlerError{
+				StatusCode: response.StatusBadRequest,
+				Message:    "Your request honestly kinda sucked.",
+			}
+		} else if path == "/myproblem" {
+			return &server.HandlerError{
+				StatusCode: response.StatusInternalServerError,
+				Message:    "Okay, you know what? This one is on me.",
+			}
+		} else if strings.HasPrefix(path, "/httpbin/") {
+			chunkNumber := path[len("/httpbin/"):]
 
 			h.Remove("Content-Length")
 			h.Set("Transfer-Encoding", "chunked")
-			h.Set("Trailer", "x-content-sha256")
-			h.Set("Trailer", "x-content-length")
+			h.Add("Trailer", "x-content-sha256")
+			h.Add("Trailer", "x-content-length")
 			url := fmt.Sprintf("https://httpbin.org/%s", chunkNumber)
+			if req.RawQuery() != "" {
+				url += "?" + req.RawQuery()
+			}
 			fmt.Println(url)
 			resp, err := http.Get(url)
 			if err != nil {
-				fmt.Println(err)
-				return
+				return fmt.Errorf("fetching %s: %v: %w", url, err, &server.HandlerError{StatusCode: response.StatusBadGateway})
 			}
+			defer resp.Body.Close()
 			w.WriteStatusLine(response.StatusOK)
 			w.WriteHeaders(h)
 			data := []byte{}
+			p := make([]byte, 32)
 			for {
-				var p = make([]byte, 32)
 				n, err := resp.Body.Read(p)
+				if n > 0 {
+					fmt.Printf("data size: %d\n", n)
+					w.WriteChunkedBody(p[:n])
+					data = append(data, p[:n]...)
+				}
 				if err != nil {
-					fmt.Println(err)
+					if err != io.EOF {
+						fmt.Println(err)
+					}
 					break
 				}
-				fmt.Printf("data size: %d\n", n)
-				w.WriteBody([]byte(fmt.Sprintf("%x\r\n", n)))
-				w.WriteBody(p[:n])
-				w.WriteBody([]byte("\r\n"))
-				data = append(data, p[:n]...)
 			}
-			w.WriteBody([]byte("0\r\n"))
 			trailers := headers.NewHeaders()
 			fmt.Printf("full body length: %d\n", len(data))
 			hash := sha256.Sum256(data)
@@ -118,33 +99,45 @@ func main() {
 			fmt.Printf("calcualted sha256: %s\n", sha256Hash)
 			trailers.Replace("X-Content-Sha256", sha256Hash)
 			trailers.Replace("X-Content-Length", fmt.Sprintf("%d", len(data)))
-			fmt.Printf("Trailer header: %s\n", h["trailer"])
-			err = w.WriteTrailers(trailers)
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-			defer resp.Body.Close()
-		} else if requestTarget == "/video" {
-			f, err := os.ReadFile("assets/vim.mp4")
+			fmt.Printf("Trailer header: %s\n", h.Values("Trailer"))
+			return w.WriteTrailers(trailers)
+		} else if path == "/video" {
+			f, err := os.Open("assets/vim.mp4")
 			if err != nil {
-				return
+				return fmt.Errorf("opening video: %v: %w", err, &server.HandlerError{StatusCode: response.StatusNotFound})
 			}
+			defer f.Close()
 			h.Replace("Content-Type", "video/mp4")
-			h.Replace("content-length", fmt.Sprintf("%d", len(f)))
 
 			w.WriteStatusLine(response.StatusOK)
 			w.WriteHeaders(h)
-			w.WriteBody(f)
+			// the file goes straight from the page cache to the socket
+			_, err = io.Copy(w, f)
+			return err
 		} else {
-			w.WriteStatusLine(response.StatusOK)
-
-			body := []byte(isOk)
-			h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
+			h.Set("Vary", "Accept")
+			contentType, err := negotiate.ContentType(req.Headers, []string{"text/html", "application/json"})
+			if err != nil {
+				return &server.HandlerError{
+					StatusCode: response.StatusNotAcceptable,
+					Message:    "This route only speaks HTML and JSON.",
+				}
+			}
+			var body []byte
+			switch {
+			case contentType == "application/json":
+				w.WriteStatusLine(response.StatusOK)
+				h.Replace("Content-Type", contentType)
+				body = []byte(isOkJSON)
+			default:
+				w.WriteStatusLine(response.StatusOK)
+				body = []byte(isOk)
+			}
 			w.WriteHeaders(h)
-			w.WriteBody(body)
+			_, err = w.WriteBody(body)
+			return err
 		}
-	})
+	}))
 
 	if err != nil {
 		log.Printf("error starting server: %v", err)
@@ -35,11 +35,16 @@ func main() {
 			req.RequestLine.HttpVersion,
 		)
 		fmt.Println("Headers:")
-		for key, val := range req.Headers {
+		for key, val := range req.Headers.All() {
 			fmt.Printf("- %s: %s\n", key, val)
 
 		}
+		body, err := req.ReadBody()
+		if err != nil {
+			slog.Error("could not read request body", "error", err)
+			return
+		}
 		fmt.Println("Body: ")
-		fmt.Println(string(req.Body))
+		fmt.Println(string(body))
 	}
 }
@@ -0,0 +1,50 @@
+package headers
+
+// irregularNames are the well known field names whose usual spelling doesn't
+// follow the capitalize-every-word rule
+var irregularNames = map[string]string{
+	"dnt":              "DNT",
+	"etag":             "ETag",
+	"te":               "TE",
+	"www-authenticate": "WWW-Authenticate",
+	"x-xss-protection": "X-XSS-Protection",
+}
+
+// CanonicalName returns the usual spelling of a field name, the first letter
+// and every letter following a hyphen upper case, e.g. "content-type" becomes
+// "Content-Type". Names that aren't valid tokens are returned unchanged.
+func CanonicalName(name string) string {
+	if invalidTokenIndex([]byte(name)) != -1 {
+		return name
+	}
+	buf := make([]byte, len(name))
+	upper := true
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		} else if !upper && c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		buf[i] = c
+		upper = c == '-'
+	}
+	if n, ok := commonNames[string(buf)]; ok {
+		return n
+	}
+	if n, ok := irregularNames[string(lowerASCII(buf))]; ok {
+		return n
+	}
+	return string(buf)
+}
+
+func lowerASCII(b []byte) []byte {
+	lower := make([]byte, len(b))
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	return lower
+}
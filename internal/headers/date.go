@@ -0,0 +1,51 @@
+package headers
+
+import (
+	"errors"
+	"time"
+)
+
+// TimeFormat is IMF-fixdate, the format every HTTP-date is sent in
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// the obsolete formats recipients still have to accept, RFC 850 and asctime
+const (
+	rfc850Format  = "Monday, 02-Jan-06 15:04:05 GMT"
+	asctimeFormat = "Mon Jan _2 15:04:05 2006"
+)
+
+var ErrInvalidDate = errors.New("invalid http date")
+
+// ParseTime parses an HTTP-date in any of the three formats RFC 9110 allows,
+// the result is always in UTC
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range []string{TimeFormat, rfc850Format, asctimeFormat} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, ErrInvalidDate
+}
+
+// FormatTime formats t as an IMF-fixdate
+func FormatTime(t time.Time) string {
+	return string(AppendTime(nil, t))
+}
+
+// AppendTime appends the IMF-fixdate form of t to b
+func AppendTime(b []byte, t time.Time) []byte {
+	return t.UTC().AppendFormat(b, TimeFormat)
+}
+
+// Time parses the HTTP-date in the key field, ok is false if the field isn't
+// present. A date that can't be parsed is an error, conditional requests
+// ignore the field in that case.
+func (h *Headers) Time(key string) (t time.Time, ok bool, err error) {
+	value, ok := h.Get(key)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	t, err = ParseTime(value)
+	return t, true, err
+}
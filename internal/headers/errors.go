@@ -0,0 +1,32 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrMalformedFieldLine = errors.New("malformed field line")
+	ErrInvalidFieldName   = errors.New("invalid field name")
+	ErrInvalidFieldValue  = errors.New("invalid field value")
+
+	ErrInvalidContentLength  = errors.New("invalid content length")
+	ErrInvalidTransferCoding = errors.New("invalid transfer coding")
+	ErrInvalidMediaType      = errors.New("invalid media type")
+)
+
+// ParseError describes a field line Headers.Parse refused. Offset is relative
+// to the start of the data handed to Parse.
+type ParseError struct {
+	Offset int
+	Field  string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("header %q at offset %d: %v", e.Field, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
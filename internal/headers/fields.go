@@ -0,0 +1,102 @@
+package headers
+
+import (
+	"iter"
+	"strconv"
+	"strings"
+)
+
+// ContentLength returns the parsed Content-Length, or -1 if the field isn't
+// present. Repeated fields, or a list in one field, are only accepted when
+// every value is the same.
+func (h *Headers) ContentLength() (int64, error) {
+	value, ok := h.Get("Content-Length")
+	if !ok {
+		return -1, nil
+	}
+	var contentLen int64 = -1
+	for part := range strings.SplitSeq(value, ",") {
+		part = strings.Trim(part, " \t")
+		if part == "" || strings.IndexFunc(part, func(c rune) bool { return c < '0' || c > '9' }) != -1 {
+			return 0, ErrInvalidContentLength
+		}
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, ErrInvalidContentLength
+		}
+		if contentLen != -1 && n != contentLen {
+			return 0, ErrInvalidContentLength
+		}
+		contentLen = n
+	}
+	return contentLen, nil
+}
+
+// ContentType returns the lowercased media type and the parameters of
+// Content-Type, an empty type and no error if the field isn't present
+func (h *Headers) ContentType() (string, map[string]string, error) {
+	value, ok := h.Get("Content-Type")
+	if !ok {
+		return "", nil, nil
+	}
+	return ParseMediaType(value)
+}
+
+// Host returns the Host field. Repeated fields come back joined like with
+// Get, a request has to carry exactly one so callers validating it should
+// check Values as well.
+func (h *Headers) Host() (string, bool) {
+	return h.Get("Host")
+}
+
+// Connection returns the lowercased connection options, empty list elements
+// are skipped
+func (h *Headers) Connection() []string {
+	var tokens []string
+	for token := range h.list("Connection") {
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// TransferEncoding returns the lowercased transfer codings in the order they
+// were applied. Empty elements or codings that aren't tokens are an error,
+// the list decides how the body is framed so it has to be read strictly.
+func (h *Headers) TransferEncoding() ([]string, error) {
+	var codings []string
+	for coding := range h.list("Transfer-Encoding") {
+		if invalidTokenIndex([]byte(coding)) != -1 {
+			return nil, ErrInvalidTransferCoding
+		}
+		codings = append(codings, coding)
+	}
+	return codings, nil
+}
+
+// Trailer returns the lowercased names of the fields announced to follow a
+// chunked body as trailers
+func (h *Headers) Trailer() []string {
+	var names []string
+	for name := range h.list("Trailer") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// list iterates over the comma separated elements of every key field,
+// trimmed and lowercased
+func (h *Headers) list(key string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for _, value := range h.Values(key) {
+			for element := range strings.SplitSeq(value, ",") {
+				if !yield(strings.ToLower(strings.Trim(element, " \t"))) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -2,22 +2,40 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"iter"
 	"slices"
 	"strings"
 )
 
 const crlf = "\r\n"
 
-type Headers map[string]string
+// Field is a single field line, Name keeps the casing it was received or set with
+type Field struct {
+	Name  string
+	Value string
+}
 
-func NewHeaders() Headers {
-	return map[string]string{}
+// Headers holds field lines in the order they were received or added. Names
+// are matched case-insensitively, repeated fields are kept as separate lines.
+type Headers struct {
+	fields []Field
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	// print the data with crlf encoding
+func NewHeaders() *Headers {
+	return &Headers{
+		fields: make([]Field, 0, 8),
+	}
+}
+
+// UseBuffer has h keep its fields in buf until it needs more room, so callers
+// can allocate the storage together with h. h must be empty.
+func (h *Headers) UseBuffer(buf []Field) {
+	h.fields = buf[:0]
+}
 
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
 		return 0, false, nil
@@ -28,61 +46,268 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return 2, true, nil
 	}
 
-	parts := bytes.SplitN(data[:idx], []byte(":"), 2)
-	key := strings.ToLower(string(parts[0]))
+	lines := section{data: data, end: idx}
+	if err := h.parseFieldLine(&lines, 0, idx); err != nil {
+		return 0, false, err
+	}
+	return idx + 2, false, nil
+}
 
-	if key != strings.TrimRight(key, " ") {
-		return 0, false, fmt.Errorf("invalid header name: %s", key)
+// ParseLines is Parse for every complete field line in data at once, up to
+// and including the empty line ending the section. It also returns how many
+// field lines were parsed. On error the offset is relative to data.
+func (h *Headers) ParseLines(data []byte) (n int, lines int, done bool, err error) {
+	// a field line is never empty, so the first empty line ends the section
+	// and nothing past it is copied
+	sec := section{data: data, end: bytes.Index(data, []byte(crlf+crlf))}
+	if sec.end == -1 {
+		sec.end = bytes.LastIndex(data, []byte(crlf))
+	}
+	for {
+		idx := bytes.Index(data[n:], []byte(crlf))
+		if idx == -1 {
+			return n, lines, false, nil
+		}
+		if idx == 0 {
+			return n + 2, lines, true, nil
+		}
+		if err := h.parseFieldLine(&sec, n, n+idx); err != nil {
+			var perr *ParseError
+			if errors.As(err, &perr) {
+				perr.Offset += n
+			}
+			return 0, 0, false, err
+		}
+		n += idx + 2
+		lines++
 	}
+}
 
-	value := bytes.TrimSpace(parts[1])
-	key = strings.TrimSpace(key)
-	if !validTokens([]byte(key)) {
-		return 0, false, fmt.Errorf("invalid header token found: %s", key)
+// section turns the field lines of one parse into strings. Common names and
+// values are interned, the rest is sliced from a single copy of the lines so
+// a header section costs one allocation rather than one per field.
+type section struct {
+	data []byte
+	end  int
+	text string
+}
+
+func (s *section) str(table map[string]string, start, end int) string {
+	if start == end {
+		return ""
 	}
-	h.Set(key, string(value))
-	return idx + 2, false, nil
+	if v, ok := table[string(s.data[start:end])]; ok {
+		return v
+	}
+	if s.text == "" {
+		s.text = string(s.data[:s.end])
+	}
+	return s.text[start:end]
 }
 
-func (h Headers) Get(key string) (string, bool) {
-	key = strings.ToLower(key)
-	v, ok := h[key]
-	return v, ok
+// parseFieldLine parses the field line at data[start:end], without its CRLF
+func (h *Headers) parseFieldLine(sec *section, start, end int) error {
+	line := sec.data[start:end]
+	if line[0] == ' ' || line[0] == '\t' {
+		// obsolete line folding or a field line starting with whitespace,
+		// both are a well known way to smuggle fields past other parsers
+		return &ParseError{Offset: 0, Field: string(line), Err: ErrMalformedFieldLine}
+	}
+	if i := bytes.IndexAny(line, "\r\n"); i != -1 {
+		return &ParseError{Offset: i, Field: string(line), Err: ErrMalformedFieldLine}
+	}
+	colon := bytes.IndexByte(line, ':')
+	if colon == -1 {
+		return &ParseError{Offset: 0, Field: string(line), Err: ErrMalformedFieldLine}
+	}
+	name := line[:colon]
+
+	// no whitespace is allowed between the field name and the colon
+	if trimmed := bytes.TrimRight(name, " \t"); len(trimmed) != len(name) {
+		return &ParseError{Offset: len(trimmed), Field: strings.ToLower(string(trimmed)), Err: ErrInvalidFieldName}
+	}
+	if i := invalidTokenIndex(name); i != -1 {
+		return &ParseError{Offset: i, Field: strings.ToLower(string(name)), Err: ErrInvalidFieldName}
+	}
+
+	rawValue := line[colon+1:]
+	if i := invalidValueIndex(rawValue); i != -1 {
+		return &ParseError{Offset: colon + 1 + i, Field: strings.ToLower(string(name)), Err: ErrInvalidFieldValue}
+	}
+	valueStart := start + colon + 1 + len(rawValue) - len(bytes.TrimLeft(rawValue, " \t"))
+	valueEnd := start + len(bytes.TrimRight(line, " \t"))
+	if valueEnd < valueStart {
+		valueEnd = valueStart
+	}
+	h.fields = append(h.fields, Field{
+		Name:  sec.str(commonNames, start, start+colon),
+		Value: sec.str(commonValues, valueStart, valueEnd),
+	})
+	return nil
 }
 
-func (h Headers) Set(key, value string) {
-	key = strings.ToLower(key)
-	v, ok := h[key]
-	if ok {
-		value = strings.Join([]string{
-			v,
-			value,
-		}, ", ")
+// Get returns the values of every field called key joined by ", ", the way
+// repeated fields are combined into one
+func (h *Headers) Get(key string) (string, bool) {
+	if h == nil {
+		return "", false
 	}
-	h[key] = value
+	value, found := "", false
+	for _, f := range h.fields {
+		if !strings.EqualFold(f.Name, key) {
+			continue
+		}
+		if found {
+			value += ", " + f.Value
+		} else {
+			value, found = f.Value, true
+		}
+	}
+	return value, found
 }
 
-func (h Headers) Replace(key, value string) {
-	key = strings.ToLower(key)
-	h[key] = value
+// Values returns the value of every field called key, one per field line
+func (h *Headers) Values(key string) []string {
+	if h == nil {
+		return nil
+	}
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, key) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
 }
 
-func (h Headers) Remove(key string) {
-	lowerKey := strings.ToLower(key)
-	delete(h, lowerKey)
+// Count returns the number of field lines called key
+func (h *Headers) Count(key string) int {
+	if h == nil {
+		return 0
+	}
+	n := 0
+	for _, f := range h.fields {
+		if strings.EqualFold(f.Name, key) {
+			n++
+		}
+	}
+	return n
+}
+
+// Add appends a field line, any existing fields called key are kept
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, Field{Name: key, Value: value})
+}
+
+// Set replaces every field called key with a single one. The field keeps the
+// position of the first one it replaces, or is appended if there was none.
+func (h *Headers) Set(key, value string) {
+	i := slices.IndexFunc(h.fields, func(f Field) bool {
+		return strings.EqualFold(f.Name, key)
+	})
+	if i == -1 {
+		h.Add(key, value)
+		return
+	}
+	h.fields[i] = Field{Name: key, Value: value}
+	rest := slices.DeleteFunc(h.fields[i+1:], func(f Field) bool {
+		return strings.EqualFold(f.Name, key)
+	})
+	h.fields = h.fields[:i+1+len(rest)]
+}
+
+// Del removes every field called key
+func (h *Headers) Del(key string) {
+	h.fields = slices.DeleteFunc(h.fields, func(f Field) bool {
+		return strings.EqualFold(f.Name, key)
+	})
+}
+
+// Replace is Set, kept for existing callers
+func (h *Headers) Replace(key, value string) {
+	h.Set(key, value)
+}
+
+// Remove is Del, kept for existing callers
+func (h *Headers) Remove(key string) {
+	h.Del(key)
+}
+
+// All iterates over the field lines in order
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if h == nil {
+			return
+		}
+		for _, f := range h.fields {
+			if !yield(f.Name, f.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns the number of field lines
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
+}
+
+// Clone returns a copy of h that can be changed without affecting h
+func (h *Headers) Clone() *Headers {
+	if h == nil {
+		return NewHeaders()
+	}
+	return &Headers{fields: slices.Clone(h.fields)}
+}
+
+// ValidateField checks that a field line can be written as is, the name has
+// to be a token and the value can't hold control characters that would let it
+// break out of its line, like the CRLF of a header injection
+func ValidateField(name, value string) error {
+	if invalidTokenIndex([]byte(name)) != -1 {
+		return fmt.Errorf("%w: %q", ErrInvalidFieldName, name)
+	}
+	if invalidValueIndex([]byte(value)) != -1 {
+		return fmt.Errorf("%w for %q", ErrInvalidFieldValue, name)
+	}
+	return nil
+}
+
+// invalidValueIndex returns the index of the first control character in a
+// field value, or -1 if there is none. RFC 9110 allows HTAB and obs-text
+// but no other CTL.
+func invalidValueIndex(value []byte) int {
+	for i, c := range value {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return i
+		}
+	}
+	return -1
 }
 
 var tokenChars = []byte{'!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~'}
 
-// validTokens checks if the data contains only valid tokens
-// or characters that are allowed in a token
-func validTokens(data []byte) bool {
-	for _, c := range data {
+// IsToken reports whether s is a non-empty token as defined in RFC 9110
+// section 5.6.2
+func IsToken(s string) bool {
+	return invalidTokenIndex([]byte(s)) == -1
+}
+
+// invalidTokenIndex returns the index of the first byte in data that is not
+// allowed in a token, or -1 if data only contains token characters
+func invalidTokenIndex(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	for i, c := range data {
 		if !isTokenChar(c) {
-			return false
+			return i
 		}
 	}
-	return true
+	return -1
 }
 
 func isTokenChar(c byte) bool {
@@ -2,6 +2,7 @@ package headers
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,7 +15,7 @@ func TestHeaders(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assertField(t, headers, "host", "localhost:42069")
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -27,13 +28,14 @@ func TestHeaders(t *testing.T) {
 	assert.False(t, done)
 
 	// Test: Valid 2 headers with existing headers
-	headers = map[string]string{"host": "localhost:42069"}
+	headers = NewHeaders()
+	headers.Add("host", "localhost:42069")
 	data = []byte("User-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, "curl/7.81.0", headers["user-agent"])
+	assertField(t, headers, "host", "localhost:42069")
+	assertField(t, headers, "user-agent", "curl/7.81.0")
 	assert.Equal(t, 25, n)
 	assert.False(t, done)
 
@@ -48,7 +50,7 @@ func TestHeaders(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assertField(t, headers, "host", "localhost:42069")
 	assert.Equal(t, 39, n)
 	assert.False(t, done)
 
@@ -59,11 +61,302 @@ func TestHeaders(t *testing.T) {
 	require.Error(t, err)
 	assert.False(t, done)
 
-	headers = map[string]string{"set-person": "lane-loves-go"}
+	headers = NewHeaders()
+	headers.Add("set-person", "lane-loves-go")
 	data = []byte("Set-Person: prime-loves-zig\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "lane-loves-go, prime-loves-zig", headers["set-person"])
+	assertField(t, headers, "set-person", "lane-loves-go, prime-loves-zig")
 	assert.False(t, done)
 }
+
+func assertField(t *testing.T, headers *Headers, key, expected string) {
+	t.Helper()
+	v, ok := headers.Get(key)
+	assert.True(t, ok, "missing %s", key)
+	assert.Equal(t, expected, v)
+}
+
+func TestHeadersParseErrors(t *testing.T) {
+	// Test: Whitespace before the colon
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("Host : localhost:42069\r\n\r\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrInvalidFieldName)
+	assert.Equal(t, 4, perr.Offset)
+	assert.Equal(t, "host", perr.Field)
+
+	// Test: Invalid token character
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("H©st: localhost:42069\r\n\r\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrInvalidFieldName)
+	assert.Equal(t, 1, perr.Offset)
+
+	// Test: Missing colon
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("Host localhost\r\n\r\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrMalformedFieldLine)
+
+	// Test: Empty field name
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte(": localhost\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidFieldName)
+
+	// Test: NUL inside a value
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Name: ab\x00c\r\n\r\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrInvalidFieldValue)
+	assert.Equal(t, 10, perr.Offset)
+	assert.Equal(t, "x-name", perr.Field)
+
+	// Test: DEL and other controls are rejected, HTAB and obs-text are not
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Name: a\x7fb\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidFieldValue)
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Name: a\x1bb\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidFieldValue)
+	headers = NewHeaders()
+	_, _, err = headers.Parse([]byte("X-Name: a\tb\xe9\r\n\r\n"))
+	require.NoError(t, err)
+	assertField(t, headers, "x-name", "a\tb\xe9")
+}
+
+func TestValidateField(t *testing.T) {
+	assert.NoError(t, ValidateField("X-Name", "a\tb"))
+	assert.ErrorIs(t, ValidateField("X Name", "a"), ErrInvalidFieldName)
+	assert.ErrorIs(t, ValidateField("", "a"), ErrInvalidFieldName)
+	assert.ErrorIs(t, ValidateField("X-Name", "a\r\nSet-Cookie: x=1"), ErrInvalidFieldValue)
+	assert.ErrorIs(t, ValidateField("X-Name", "a\nb"), ErrInvalidFieldValue)
+	assert.ErrorIs(t, ValidateField("X-Name", "a\x00"), ErrInvalidFieldValue)
+}
+
+func TestHeadersParseLines(t *testing.T) {
+	// Test: Every complete line is parsed in one call
+	headers := NewHeaders()
+	data := []byte("Host: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\nbody")
+	n, lines, done, err := headers.ParseLines(data)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, len(data)-len("body"), n)
+	assert.Equal(t, 3, lines)
+	assertField(t, headers, "user-agent", "curl/7.81.0")
+	assertField(t, headers, "accept", "*/*")
+
+	// Test: Incomplete trailing line is left for the next call
+	headers = NewHeaders()
+	n, lines, done, err = headers.ParseLines([]byte("Host: localhost:42069\r\nAcc"))
+	require.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, 23, n)
+	assert.Equal(t, 1, lines)
+
+	// Test: Error offsets are relative to the data
+	headers = NewHeaders()
+	_, _, _, err = headers.ParseLines([]byte("Host: localhost:42069\r\nBad Name: x\r\n\r\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, 26, perr.Offset)
+
+	// Test: Field names longer than the lowercasing buffer
+	headers = NewHeaders()
+	_, _, _, err = headers.ParseLines([]byte("X-A-Very-Long-Header-Name-Indeed-Yes: 1\r\n\r\n"))
+	require.NoError(t, err)
+	v, ok := headers.Get("x-a-very-long-header-name-indeed-yes")
+	assert.True(t, ok)
+	assert.Equal(t, "1", v)
+
+	// Test: Padded and empty values, the strings share one copy of the section
+	headers = NewHeaders()
+	data = []byte("X-Padded: \t a b \t\r\nX-Empty:  \r\nX-Other:c\r\n\r\n")
+	allocs := testing.AllocsPerRun(10, func() {
+		headers.fields = headers.fields[:0]
+		_, _, _, err = headers.ParseLines(data)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, allocs)
+	assertField(t, headers, "x-padded", "a b")
+	assertField(t, headers, "x-empty", "")
+	assertField(t, headers, "x-other", "c")
+	assert.Equal(t, 1, headers.Count("X-PADDED"))
+}
+
+func BenchmarkHeadersParseLines(b *testing.B) {
+	data := []byte("Host: localhost:42069\r\n" +
+		"User-Agent: curl/7.81.0\r\n" +
+		"Accept: */*\r\n" +
+		"Connection: keep-alive\r\n" +
+		"\r\n")
+	headers := NewHeaders()
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		headers.fields = headers.fields[:0]
+		if _, _, _, err := headers.ParseLines(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestHeadersMultiValue(t *testing.T) {
+	// Test: Field lines keep their order, casing and separate values
+	headers := NewHeaders()
+	_, _, done, err := headers.ParseLines([]byte("Host: localhost:42069\r\n" +
+		"Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\n" +
+		"X-Custom: one\r\n" +
+		"set-cookie: b=2\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+	require.True(t, done)
+	assert.Equal(t, 4, headers.Len())
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, headers.Values("Set-Cookie"))
+	assert.Nil(t, headers.Values("missing"))
+
+	var names []string
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Host", "Set-Cookie", "X-Custom", "set-cookie"}, names)
+
+	// Test: Add appends, Set replaces every field in place of the first
+	headers.Add("X-Custom", "two")
+	assert.Equal(t, []string{"one", "two"}, headers.Values("x-custom"))
+	headers.Set("set-COOKIE", "c=3")
+	assert.Equal(t, []string{"c=3"}, headers.Values("Set-Cookie"))
+	names = names[:0]
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Host", "set-COOKIE", "X-Custom", "X-Custom"}, names)
+
+	// Test: Del removes every field
+	headers.Del("x-custom")
+	assert.Equal(t, 2, headers.Len())
+	_, ok := headers.Get("X-Custom")
+	assert.False(t, ok)
+
+	// Test: Replace and Remove still work for existing callers
+	headers.Replace("Host", "example.com")
+	assertField(t, headers, "host", "example.com")
+	headers.Remove("HOST")
+	_, ok = headers.Get("host")
+	assert.False(t, ok)
+
+	// Test: Clone is independent of the original
+	clone := headers.Clone()
+	clone.Add("X-Clone", "1")
+	assert.Equal(t, 1, headers.Len())
+	assert.Equal(t, 2, clone.Len())
+
+	// Test: Reading nil headers
+	var nilHeaders *Headers
+	_, ok = nilHeaders.Get("host")
+	assert.False(t, ok)
+	assert.Equal(t, 0, nilHeaders.Len())
+}
+
+func TestCanonicalName(t *testing.T) {
+	assert.Equal(t, "Content-Type", CanonicalName("content-type"))
+	assert.Equal(t, "Content-Type", CanonicalName("CONTENT-TYPE"))
+	assert.Equal(t, "X-Content-Sha256", CanonicalName("x-content-sha256"))
+	assert.Equal(t, "Host", CanonicalName("Host"))
+	assert.Equal(t, "TE", CanonicalName("te"))
+	assert.Equal(t, "WWW-Authenticate", CanonicalName("www-authenticate"))
+	assert.Equal(t, "bad name", CanonicalName("bad name"))
+}
+
+func TestHeadersTypedFields(t *testing.T) {
+	// Test: Content-Length
+	h := NewHeaders()
+	n, err := h.ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), n)
+	h.Add("Content-Length", "42")
+	h.Add("Content-Length", "42")
+	n, err = h.ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+	h.Set("Content-Length", "42, 43")
+	_, err = h.ContentLength()
+	assert.ErrorIs(t, err, ErrInvalidContentLength)
+	h.Set("Content-Length", "+42")
+	_, err = h.ContentLength()
+	assert.ErrorIs(t, err, ErrInvalidContentLength)
+
+	// Test: Content-Type with parameters
+	h = NewHeaders()
+	mediaType, params, err := h.ContentType()
+	require.NoError(t, err)
+	assert.Empty(t, mediaType)
+	assert.Nil(t, params)
+	h.Add("Content-Type", `Multipart/Form-Data; Boundary="a \"b\""; charset=utf-8`)
+	mediaType, params, err = h.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+	assert.Equal(t, map[string]string{"boundary": `a "b"`, "charset": "utf-8"}, params)
+	h.Set("Content-Type", `text/plain; charset="utf-8`)
+	_, _, err = h.ContentType()
+	assert.ErrorIs(t, err, ErrInvalidMediaType)
+
+	// Test: Host
+	h = NewHeaders()
+	_, ok := h.Host()
+	assert.False(t, ok)
+	h.Add("host", "localhost:42069")
+	host, ok := h.Host()
+	assert.True(t, ok)
+	assert.Equal(t, "localhost:42069", host)
+
+	// Test: Connection tokens across fields
+	h = NewHeaders()
+	assert.Nil(t, h.Connection())
+	h.Add("Connection", "Keep-Alive, ,Upgrade")
+	h.Add("Connection", "close")
+	assert.Equal(t, []string{"keep-alive", "upgrade", "close"}, h.Connection())
+
+	// Test: Transfer-Encoding list
+	h = NewHeaders()
+	h.Add("Transfer-Encoding", "gzip")
+	h.Add("Transfer-Encoding", " Chunked ")
+	codings, err := h.TransferEncoding()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gzip", "chunked"}, codings)
+	h.Set("Transfer-Encoding", "chunked,")
+	_, err = h.TransferEncoding()
+	assert.ErrorIs(t, err, ErrInvalidTransferCoding)
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+	for _, value := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		got, err := ParseTime(value)
+		require.NoError(t, err, value)
+		assert.True(t, want.Equal(got), value)
+	}
+
+	_, err := ParseTime("Sun, 06 Nov 1994 08:49:37 PST")
+	assert.ErrorIs(t, err, ErrInvalidDate)
+	_, err = ParseTime("1994-11-06T08:49:37Z")
+	assert.ErrorIs(t, err, ErrInvalidDate)
+
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatTime(want.In(time.FixedZone("EST", -5*3600))))
+
+	h := NewHeaders()
+	_, ok, err := h.Time("If-Modified-Since")
+	assert.False(t, ok)
+	require.NoError(t, err)
+	h.Add("If-Modified-Since", "Sun Nov  6 08:49:37 1994")
+	got, ok, err := h.Time("If-Modified-Since")
+	assert.True(t, ok)
+	require.NoError(t, err)
+	assert.True(t, want.Equal(got))
+}
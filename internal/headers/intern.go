@@ -0,0 +1,33 @@
+package headers
+
+// the parser hands out these strings instead of allocating a new one for
+// every request that sends a common field name or value
+
+// field names are kept as received, so both the usual and the lowercase
+// spelling are interned
+var commonNames = internTable(
+	"Accept", "Accept-Encoding", "Accept-Language", "Authorization",
+	"Cache-Control", "Connection", "Content-Length", "Content-Type", "Cookie",
+	"Expect", "Host", "If-Modified-Since", "If-None-Match", "Origin", "Referer",
+	"TE", "Trailer", "Transfer-Encoding", "Upgrade", "User-Agent",
+	"X-Forwarded-For",
+	"accept", "accept-encoding", "accept-language", "authorization",
+	"cache-control", "connection", "content-length", "content-type", "cookie",
+	"expect", "host", "if-modified-since", "if-none-match", "origin", "referer",
+	"te", "trailer", "transfer-encoding", "upgrade", "user-agent",
+	"x-forwarded-for",
+)
+
+var commonValues = internTable(
+	"*/*", "close", "keep-alive", "chunked", "100-continue", "gzip",
+	"gzip, deflate", "gzip, deflate, br", "no-cache", "application/json",
+	"text/plain", "text/html", "0",
+)
+
+func internTable(values ...string) map[string]string {
+	table := make(map[string]string, len(values))
+	for _, v := range values {
+		table[v] = v
+	}
+	return table
+}
@@ -0,0 +1,67 @@
+package headers
+
+import (
+	"strings"
+)
+
+// ParseMediaType splits a Content-Type or Content-Disposition style value into
+// its lowercased type and its parameters. Parameter names are lowercased,
+// quoted values are unquoted.
+func ParseMediaType(value string) (string, map[string]string, error) {
+	mediaType, rest, _ := strings.Cut(value, ";")
+	mediaType = strings.ToLower(strings.Trim(mediaType, " \t"))
+	if mediaType == "" {
+		return "", nil, ErrInvalidMediaType
+	}
+
+	params := map[string]string{}
+	for {
+		rest = strings.TrimLeft(rest, " \t;")
+		if rest == "" {
+			return mediaType, params, nil
+		}
+		name, after, ok := strings.Cut(rest, "=")
+		name = strings.ToLower(strings.Trim(name, " \t"))
+		if !ok || name == "" {
+			return "", nil, ErrInvalidMediaType
+		}
+		after = strings.TrimLeft(after, " \t")
+
+		var paramValue string
+		if strings.HasPrefix(after, `"`) {
+			v, n, ok := unquote(after)
+			if !ok {
+				return "", nil, ErrInvalidMediaType
+			}
+			paramValue, rest = v, after[n:]
+		} else {
+			end := strings.IndexByte(after, ';')
+			if end == -1 {
+				end = len(after)
+			}
+			paramValue, rest = strings.Trim(after[:end], " \t"), after[end:]
+		}
+		params[name] = paramValue
+	}
+}
+
+// unquote reads the quoted-string at the start of s, it returns the unescaped
+// value and how many bytes of s it took up
+func unquote(s string) (string, int, bool) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return sb.String(), i + 1, true
+		case '\\':
+			if i+1 == len(s) {
+				return "", 0, false
+			}
+			i++
+			sb.WriteByte(s[i])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", 0, false
+}
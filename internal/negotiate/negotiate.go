@@ -0,0 +1,219 @@
+// Package negotiate picks the representation to send from what the client
+// listed in its Accept, Accept-Encoding and Accept-Language fields.
+package negotiate
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+)
+
+// ErrNotAcceptable is returned when none of the offers is acceptable to the
+// client, the handler should answer with 406 Not Acceptable
+var ErrNotAcceptable = errors.New("no acceptable offer")
+
+// acceptRange is one element of an Accept* field
+type acceptRange struct {
+	value  string
+	params map[string]string
+	q      float64
+	// implicit is set on the identity range Encoding adds on its own
+	implicit bool
+}
+
+// matchFunc reports whether rng covers offer, and how specific the match is.
+// A more specific range wins over a less specific one with the same q.
+type matchFunc func(rng acceptRange, offer string) (specificity int, ok bool)
+
+// ContentType returns the offered media type the client prefers according to
+// Accept. Offers are media types like "application/json", they may carry
+// parameters that a media range has to match. The first offer wins when
+// Accept isn't present.
+func ContentType(h *headers.Headers, offers []string) (string, error) {
+	value, ok := h.Get("Accept")
+	if !ok || strings.Trim(value, " \t") == "" {
+		return first(offers)
+	}
+	return best(parseAccept(value, true), offers, matchMediaType)
+}
+
+// Encoding returns the offered content coding the client prefers according
+// to Accept-Encoding. The identity coding is acceptable unless the client
+// excluded it, with "identity;q=0" or with "*;q=0" and no identity entry.
+func Encoding(h *headers.Headers, offers []string) (string, error) {
+	value, ok := h.Get("Accept-Encoding")
+	if !ok {
+		return first(offers)
+	}
+	ranges := parseAccept(value, false)
+	if !listsIdentity(ranges) {
+		ranges = append(ranges, acceptRange{value: "identity", q: 1, implicit: true})
+	}
+	return best(ranges, offers, matchToken)
+}
+
+// Language returns the offered language tag the client prefers according to
+// Accept-Language, ranges match tags by prefix so "en" covers "en-GB"
+func Language(h *headers.Headers, offers []string) (string, error) {
+	value, ok := h.Get("Accept-Language")
+	if !ok || strings.Trim(value, " \t") == "" {
+		return first(offers)
+	}
+	return best(parseAccept(value, false), offers, matchLanguage)
+}
+
+func first(offers []string) (string, error) {
+	if len(offers) == 0 {
+		return "", ErrNotAcceptable
+	}
+	return offers[0], nil
+}
+
+// best returns the offer with the highest q. Ties go to the more specific
+// range, then to the order of offers, the handler lists its own preference.
+func best(ranges []acceptRange, offers []string, match matchFunc) (string, error) {
+	bestOffer := ""
+	bestQ, bestSpecificity := 0.0, -1
+	for _, offer := range offers {
+		q, specificity := qualityOf(ranges, offer, match)
+		if q > bestQ || q == bestQ && q > 0 && specificity > bestSpecificity {
+			bestOffer, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+	if bestQ == 0 {
+		return "", ErrNotAcceptable
+	}
+	return bestOffer, nil
+}
+
+// qualityOf returns the q of the most specific range covering offer, an
+// offer no range covers isn't acceptable
+func qualityOf(ranges []acceptRange, offer string, match matchFunc) (float64, int) {
+	q, bestSpecificity := 0.0, -1
+	for _, rng := range ranges {
+		specificity, ok := match(rng, offer)
+		if ok && specificity > bestSpecificity {
+			q, bestSpecificity = rng.q, specificity
+		}
+	}
+	return q, bestSpecificity
+}
+
+// parseAccept splits an Accept* value into its ranges. Malformed elements
+// are skipped rather than failing the whole field. Media ranges keep their
+// parameters, the other fields only carry a weight.
+func parseAccept(value string, withParams bool) []acceptRange {
+	var ranges []acceptRange
+	for element := range strings.SplitSeq(value, ",") {
+		name, paramList, _ := strings.Cut(element, ";")
+		rng := acceptRange{value: strings.ToLower(strings.Trim(name, " \t")), q: 1}
+		if rng.value == "" {
+			continue
+		}
+		valid := true
+		for param := range strings.SplitSeq(paramList, ";") {
+			key, paramValue, ok := strings.Cut(param, "=")
+			key = strings.ToLower(strings.Trim(key, " \t"))
+			paramValue = strings.Trim(paramValue, " \t")
+			switch {
+			case key == "" && !ok:
+				// nothing between two semicolons, or no parameters at all
+			case key == "q":
+				q, ok := parseQuality(paramValue)
+				if !ok {
+					valid = false
+				}
+				rng.q = q
+			case withParams:
+				if rng.params == nil {
+					rng.params = map[string]string{}
+				}
+				rng.params[key] = strings.Trim(paramValue, `"`)
+			}
+		}
+		if valid {
+			ranges = append(ranges, rng)
+		}
+	}
+	return ranges
+}
+
+// parseQuality parses a weight, a number from 0 to 1 with at most three
+// decimals
+func parseQuality(s string) (float64, bool) {
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(whole) != 1 || len(frac) > 3 || strings.Trim(frac, "0123456789") != "" {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
+
+func matchMediaType(rng acceptRange, offer string) (int, bool) {
+	mediaType, params, err := headers.ParseMediaType(offer)
+	if err != nil {
+		return 0, false
+	}
+	rangeType, rangeSubtype, ok := strings.Cut(rng.value, "/")
+	if !ok {
+		return 0, false
+	}
+	offerType, offerSubtype, _ := strings.Cut(mediaType, "/")
+
+	specificity := 0
+	switch {
+	case rangeType == "*" && rangeSubtype == "*":
+	case rangeType == offerType && rangeSubtype == "*":
+		specificity = 1
+	case rangeType == offerType && rangeSubtype == offerSubtype:
+		specificity = 2
+	default:
+		return 0, false
+	}
+	for key, value := range rng.params {
+		if !strings.EqualFold(params[key], value) {
+			return 0, false
+		}
+	}
+	return specificity*10 + len(rng.params), true
+}
+
+func matchToken(rng acceptRange, offer string) (int, bool) {
+	switch {
+	case strings.EqualFold(rng.value, offer) && !rng.implicit:
+		return 1, true
+	case strings.EqualFold(rng.value, offer), rng.value == "*":
+		// a coding the client didn't name loses a tie to one it did
+		return 0, true
+	}
+	return 0, false
+}
+
+func matchLanguage(rng acceptRange, offer string) (int, bool) {
+	offer = strings.ToLower(offer)
+	switch {
+	case rng.value == "*":
+		return 0, true
+	case offer == rng.value, strings.HasPrefix(offer, rng.value+"-"):
+		return len(rng.value), true
+	}
+	return 0, false
+}
+
+func listsIdentity(ranges []acceptRange) bool {
+	for _, rng := range ranges {
+		if rng.value == "identity" {
+			return true
+		}
+		if rng.value == "*" && rng.q == 0 {
+			// identity was excluded along with everything not listed
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package negotiate
+
+import (
+	"testing"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withField(key, value string) *headers.Headers {
+	h := headers.NewHeaders()
+	h.Add(key, value)
+	return h
+}
+
+func TestContentType(t *testing.T) {
+	offers := []string{"text/html", "application/json"}
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+		err    error
+	}{
+		{name: "exact match", accept: "application/json", want: "application/json"},
+		{name: "highest q wins", accept: "text/html;q=0.5, application/json;q=0.9", want: "application/json"},
+		{name: "specific range beats wildcard", accept: "application/json, */*", want: "application/json"},
+		{name: "wildcard takes handler order", accept: "*/*", want: "text/html"},
+		{name: "subtype wildcard", accept: "application/*", want: "application/json"},
+		{name: "more specific range sets q", accept: "text/*;q=0.1, text/html;q=0, */*;q=0.2", want: "application/json"},
+		{name: "excluded with q=0", accept: "text/html;q=0, application/json;q=0", err: ErrNotAcceptable},
+		{name: "nothing matches", accept: "image/png", err: ErrNotAcceptable},
+		{name: "case insensitive", accept: "Application/JSON", want: "application/json"},
+		{name: "malformed q skipped", accept: "text/html;q=2, application/json;q=0.1", want: "application/json"},
+		{name: "empty elements ignored", accept: ", ,application/json", want: "application/json"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ContentType(withField("Accept", tc.accept), offers)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	// Test: No Accept field means anything goes
+	got, err := ContentType(headers.NewHeaders(), offers)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", got)
+
+	// Test: Range parameters have to match the offer
+	got, err = ContentType(withField("Accept", "text/plain;charset=utf-8, text/plain;q=0.1"),
+		[]string{"text/plain; charset=latin1", "text/plain; charset=UTF-8"})
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=UTF-8", got)
+}
+
+func TestEncoding(t *testing.T) {
+	offers := []string{"identity", "gzip", "br"}
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+		err    error
+	}{
+		{name: "listed coding beats implicit identity", accept: "gzip", want: "gzip"},
+		{name: "highest q wins", accept: "gzip;q=0.5, br", want: "br"},
+		{name: "empty means identity only", accept: "", want: "identity"},
+		{name: "unlisted identity still acceptable", accept: "deflate", want: "identity"},
+		{name: "identity excluded", accept: "identity;q=0, deflate", err: ErrNotAcceptable},
+		{name: "wildcard excludes identity", accept: "*;q=0", err: ErrNotAcceptable},
+		{name: "wildcard", accept: "*", want: "identity"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Encoding(withField("Accept-Encoding", tc.accept), offers)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	offers := []string{"en-US", "fr", "de-CH"}
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+		err    error
+	}{
+		{name: "exact tag", accept: "fr", want: "fr"},
+		{name: "prefix range", accept: "de;q=0.8, en;q=0.5", want: "de-CH"},
+		{name: "prefix has to end on a subtag", accept: "e", err: ErrNotAcceptable},
+		{name: "longer range doesn't match shorter tag", accept: "fr-CA", err: ErrNotAcceptable},
+		{name: "wildcard", accept: "*;q=0.1, fr;q=0", want: "en-US"},
+		{name: "case insensitive", accept: "EN-us", want: "en-US"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Language(withField("Accept-Language", tc.accept), offers)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
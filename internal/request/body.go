@@ -0,0 +1,267 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+)
+
+// bufferSize fits the head of nearly every request, so the common case
+// never grows the buffer
+const bufferSize = 4096
+
+var errBodyClosed = errors.New("read on closed body")
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, bufferSize)
+		return &buf
+	},
+}
+
+// connBuffer holds the bytes that have been read from the connection but not
+// parsed yet
+type connBuffer struct {
+	reader      io.Reader
+	buf         []byte
+	pooled      *[]byte
+	readToIndex int
+}
+
+func (c *connBuffer) buffered() []byte {
+	return c.buf[:c.readToIndex]
+}
+
+func (c *connBuffer) consume(n int) {
+	copy(c.buf, c.buf[n:c.readToIndex])
+	c.readToIndex -= n
+}
+
+// fill reads more data from the connection, growing the buffer if it is full
+func (c *connBuffer) fill() error {
+	if c.buf == nil {
+		c.pooled = bufferPool.Get().(*[]byte)
+		c.buf = *c.pooled
+	}
+	if c.readToIndex >= len(c.buf) {
+		newBuf := make([]byte, len(c.buf)*2)
+		copy(newBuf, c.buf)
+		if c.pooled != nil {
+			// only buffers of the default size go back in the pool
+			bufferPool.Put(c.pooled)
+			c.pooled = nil
+		}
+		c.buf = newBuf
+	}
+
+	n, err := c.reader.Read(c.buf[c.readToIndex:])
+	c.readToIndex += n
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		// a reader returning no data and no error is allowed, just try again later
+		return nil
+	}
+	return err
+}
+
+// release hands the pooled buffer back, anything still buffered is dropped
+func (c *connBuffer) release() {
+	if c.pooled != nil {
+		bufferPool.Put(c.pooled)
+		c.pooled = nil
+	}
+	c.buf = nil
+	c.readToIndex = 0
+}
+
+// body is the io.ReadCloser handed out as Request.Body, it decodes the message
+// body from the connection as the handler reads it
+type body struct {
+	req    *Request
+	src    *connBuffer
+	closed bool
+	// owner is set when the body is the last user of its Reader's buffer
+	owner *Reader
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for b.req.state != requestStateDone {
+		consumed, written, err := b.req.parseBody(b.src.buffered(), p)
+		if err != nil {
+			return 0, b.req.located(err)
+		}
+		b.src.consume(consumed)
+		b.req.offset += int64(consumed)
+		if written > 0 {
+			return written, nil
+		}
+		if consumed > 0 {
+			continue
+		}
+
+		if send := b.req.continueFunc; send != nil {
+			// the client is holding the body back until it hears from us
+			b.req.continueFunc = nil
+			if err := send(); err != nil {
+				return 0, err
+			}
+		}
+		if err := b.src.fill(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	b.releaseBuffer()
+	return 0, io.EOF
+}
+
+func (b *body) Close() error {
+	b.closed = true
+	b.releaseBuffer()
+	return nil
+}
+
+func (b *body) releaseBuffer() {
+	if b.owner != nil {
+		b.owner.Release()
+		b.owner = nil
+	}
+}
+
+// discard reads and drops what is left of the body, closed or not, so the
+// connection is positioned at the start of the next request
+func (b *body) discard() error {
+	if b.req.state == requestStateDone {
+		return nil
+	}
+	// a client still waiting for 100 Continue won't send the body, the
+	// connection can't be reused and there is nothing to discard
+	if b.req.continueFunc != nil {
+		return ErrBodyNotSent
+	}
+	closed := b.closed
+	b.closed = false
+	_, err := io.Copy(io.Discard, b)
+	b.closed = closed
+	return err
+}
+
+// parseBody decodes as much of the body from data into p as the current state
+// allows. it returns the number of bytes consumed from data and the number of
+// body bytes written into p
+func (r *Request) parseBody(data, p []byte) (int, int, error) {
+	switch r.state {
+	case requestStateParsingBody:
+		n := copy(p, data[:min(int64(len(data)), r.contentLength-r.bodyLengthRead)])
+		r.bodyLengthRead += int64(n)
+		if r.bodyLengthRead == r.contentLength {
+			r.state = requestStateDone
+		}
+		return n, n, nil
+	case requestStateParsingChunkSize:
+		size, idx, err := parseChunkSize(data)
+		if err != nil {
+			return 0, 0, err
+		}
+		if idx == 0 {
+			if len(data) > maxChunkSizeLineLength {
+				return 0, 0, &ParseError{Field: "chunk-size", StatusCode: statusBadRequest, Err: ErrMalformedChunk}
+			}
+			// need more data
+			return 0, 0, nil
+		}
+		if size > r.limits.MaxBodySize-r.bodyLengthRead {
+			return 0, 0, &ParseError{Field: "chunk-size", StatusCode: statusContentTooLarge, Err: ErrBodyTooLarge}
+		}
+		if size == 0 {
+			// the last chunk, only trailer fields are left
+			r.Trailers = headers.NewHeaders()
+			r.state = requestStateParsingTrailers
+			return idx, 0, nil
+		}
+		r.chunkRemaining = size
+		r.state = requestStateParsingChunkData
+		return idx, 0, nil
+	case requestStateParsingChunkData:
+		n := copy(p, data[:min(int64(len(data)), r.chunkRemaining)])
+		r.bodyLengthRead += int64(n)
+		r.chunkRemaining -= int64(n)
+		if r.chunkRemaining == 0 {
+			r.state = requestStateParsingChunkDataEnd
+		}
+		return n, n, nil
+	case requestStateParsingChunkDataEnd:
+		if len(data) < len(crlf) {
+			return 0, 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, 0, &ParseError{Field: "chunk-data", StatusCode: statusBadRequest, Err: ErrMalformedChunk}
+		}
+		r.state = requestStateParsingChunkSize
+		return len(crlf), 0, nil
+	case requestStateParsingTrailers:
+		n, done, err := r.parseFieldLines(r.Trailers, data)
+		if err != nil {
+			return 0, 0, err
+		}
+		if done {
+			r.state = requestStateDone
+		}
+		return n, 0, nil
+	case requestStateDone:
+		return 0, 0, io.EOF
+	default:
+		return 0, 0, fmt.Errorf("unknown state")
+	}
+}
+
+// parseChunkSize parses a chunk-size line, ignoring any chunk extensions.
+// it returns 0 bytes consumed if the line is not complete yet
+func parseChunkSize(data []byte) (int64, int, error) {
+	idx := bytes.Index(data, []byte(crlf))
+	if idx == -1 {
+		return 0, 0, nil
+	}
+	line := data[:idx]
+	if semi := bytes.IndexByte(line, ';'); semi != -1 {
+		// the extensions are ignored but still have to be clean, a bare CR
+		// or LF in them ends the line early for a parser that accepts one
+		if !validChunkExt(line[semi+1:]) {
+			return 0, 0, &ParseError{Field: "chunk-ext", StatusCode: statusBadRequest, Err: ErrMalformedChunk}
+		}
+		line = line[:semi]
+	}
+	line = bytes.TrimRight(line, " \t")
+	size, err := strconv.ParseUint(string(line), 16, 63)
+	if err != nil {
+		return 0, 0, &ParseError{Field: "chunk-size", StatusCode: statusBadRequest, Err: ErrMalformedChunk}
+	}
+	return int64(size), idx + 2, nil
+}
+
+// validChunkExt reports whether ext is free of control characters, other than
+// the tab allowed as whitespace
+func validChunkExt(ext []byte) bool {
+	for _, c := range ext {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
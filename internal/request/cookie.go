@@ -0,0 +1,65 @@
+package request
+
+import (
+	"strings"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+)
+
+// Cookie is a single name value pair from the Cookie header
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// Cookies returns the cookies sent by the client in the order they were sent.
+// Malformed pairs are skipped, a broken cookie shouldn't fail the request.
+func (r *Request) Cookies() []Cookie {
+	if r.cookies == nil {
+		cookieHeader, _ := r.Headers.Get("Cookie")
+		r.cookies = parseCookies(cookieHeader)
+	}
+	return r.cookies
+}
+
+// Cookie returns the value of the first cookie called name
+func (r *Request) Cookie(name string) (string, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
+func parseCookies(value string) []Cookie {
+	cookies := []Cookie{}
+	// pairs are separated by "; ", a "," can only show up when several Cookie
+	// fields were joined by the headers parser since it isn't a cookie-octet
+	for pair := range strings.FieldsFuncSeq(value, func(c rune) bool { return c == ';' || c == ',' }) {
+		name, cookieValue, ok := strings.Cut(strings.Trim(pair, " \t"), "=")
+		if !ok || name == "" || !headers.IsToken(name) {
+			continue
+		}
+		cookieValue = strings.Trim(cookieValue, " \t")
+		if len(cookieValue) > 1 && cookieValue[0] == '"' && cookieValue[len(cookieValue)-1] == '"' {
+			cookieValue = cookieValue[1 : len(cookieValue)-1]
+		}
+		if !validCookieValue(cookieValue) {
+			continue
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: cookieValue})
+	}
+	return cookies
+}
+
+// validCookieValue checks for cookie-octets as defined by RFC 6265
+func validCookieValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+)
+
+// the status codes suggested by ParseError, kept here so the parser doesn't
+// need to know about the response package
+const (
+	statusBadRequest                  = 400
+	statusContentTooLarge             = 413
+	statusURITooLong                  = 414
+	statusExpectationFailed           = 417
+	statusRequestHeaderFieldsTooLarge = 431
+	statusNotImplemented              = 501
+	statusHTTPVersionNotSupported     = 505
+)
+
+var (
+	ErrMalformedRequestLine   = errors.New("malformed request line")
+	ErrInvalidMethod          = errors.New("invalid method")
+	ErrUnknownMethod          = errors.New("unknown method")
+	ErrMalformedVersion       = errors.New("malformed http version")
+	ErrUnsupportedVersion     = errors.New("unsupported http version")
+	ErrMissingHost            = errors.New("missing host header")
+	ErrDuplicateHost          = errors.New("more than one host header")
+	ErrUnsupportedExpectation = errors.New("unsupported expectation")
+	ErrInvalidContentLength   = headers.ErrInvalidContentLength
+	ErrConflictingFraming     = errors.New("both transfer-encoding and content-length present")
+	ErrTransferEncoding10     = errors.New("transfer-encoding in an http/1.0 request")
+
+	ErrInvalidTransferEncoding   = errors.New("invalid transfer encoding")
+	ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
+	ErrMalformedChunk            = errors.New("malformed chunk")
+	ErrIncompleteRequest         = errors.New("incomplete request")
+	ErrBodyNotSent               = errors.New("body held back waiting for 100 continue")
+)
+
+// ParseError is returned for any request the parser refuses. Offset is the
+// byte offset in the request where the problem was found, Field names the
+// part of the request at fault (e.g. "method" or a header name) and
+// StatusCode is the response status the server should answer with.
+type ParseError struct {
+	Offset     int64
+	Field      string
+	StatusCode int
+	Err        error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at offset %d: %v", e.Field, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// located turns an error found relative to the unparsed data into a
+// ParseError with an absolute offset in the request
+func (r *Request) located(err error) error {
+	var perr *ParseError
+	if errors.As(err, &perr) {
+		perr.Offset += r.offset
+		return perr
+	}
+	var herr *headers.ParseError
+	if errors.As(err, &herr) {
+		return &ParseError{
+			Offset:     r.offset + int64(herr.Offset),
+			Field:      herr.Field,
+			StatusCode: statusBadRequest,
+			Err:        herr,
+		}
+	}
+	return err
+}
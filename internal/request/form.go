@@ -0,0 +1,48 @@
+package request
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	ErrNotForm          = errors.New("request body is not a form")
+	ErrInvalidForm      = errors.New("invalid form encoding")
+	ErrNotMultipart     = errors.New("request body is not multipart/form-data")
+	ErrMalformedPart    = errors.New("malformed multipart body")
+	ErrPartTooLarge     = errors.New("multipart part too large")
+	ErrPartHeadTooLarge = errors.New("multipart part headers too large")
+)
+
+// Values maps form or query keys to their values in the order they were sent
+type Values map[string][]string
+
+// Get returns the first value of key, or "" if key isn't present
+func (v Values) Get(key string) string {
+	if values := v[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ParseForm reads the whole body and decodes it as an
+// application/x-www-form-urlencoded form
+func (r *Request) ParseForm() (Values, error) {
+	mediaType, _, err := r.Headers.ContentType()
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return nil, ErrNotForm
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	form, err := parseQuery(string(data))
+	if err != nil {
+		return nil, ErrInvalidForm
+	}
+	if form == nil {
+		form = Values{}
+	}
+	return form, nil
+}
@@ -0,0 +1,187 @@
+package request
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForm(t *testing.T) {
+	// Test: URL-encoded form
+	body := "name=Lane+Wagner&lang=go&lang=zig&note=100%25"
+	r, err := RequestFromReader(strings.NewReader("POST /form HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: application/x-www-form-urlencoded; charset=utf-8\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" + body))
+	require.NoError(t, err)
+	form, err := r.ParseForm()
+	require.NoError(t, err)
+	assert.Equal(t, "Lane Wagner", form.Get("name"))
+	assert.Equal(t, []string{"go", "zig"}, form["lang"])
+	assert.Equal(t, "100%", form.Get("note"))
+	assert.Equal(t, "", form.Get("missing"))
+
+	// Test: Bad escape in the form
+	body = "name=%zz"
+	r, err = RequestFromReader(strings.NewReader("POST /form HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" + body))
+	require.NoError(t, err)
+	_, err = r.ParseForm()
+	assert.ErrorIs(t, err, ErrInvalidForm)
+
+	// Test: Not a form
+	r, err = RequestFromReader(strings.NewReader("POST /form HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Length: 2\r\n" +
+		"\r\n{}"))
+	require.NoError(t, err)
+	_, err = r.ParseForm()
+	assert.ErrorIs(t, err, ErrNotForm)
+}
+
+const multipartBody = "preamble to be ignored\r\n" +
+	"--xYzZY\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n" +
+	"\r\n" +
+	"my upload\r\n" +
+	"--xYzZY\r\n" +
+	"Content-Disposition: form-data; name=\"file\"; filename=\"notes.txt\"\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"line one\r\nline two --xYzZ not quite a boundary\r\n" +
+	"--xYzZY  \r\n" +
+	"Content-Disposition: form-data; name=\"file\"; filename=\"empty.txt\"\r\n" +
+	"\r\n" +
+	"\r\n" +
+	"--xYzZY--\r\n" +
+	"epilogue"
+
+func multipartRequest(t *testing.T, body string, numBytesPerRead int) *Request {
+	r, err := RequestFromReader(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Type: multipart/form-data; boundary=\"xYzZY\"\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+			"\r\n" + body,
+		numBytesPerRead: numBytesPerRead,
+	})
+	require.NoError(t, err)
+	return r
+}
+
+func TestMultipartReader(t *testing.T) {
+	// Test: Parts are streamed in order, whatever the read size
+	for _, numBytesPerRead := range []int{1, 3, 1024} {
+		r := multipartRequest(t, multipartBody, numBytesPerRead)
+		mr, err := r.MultipartReader()
+		require.NoError(t, err)
+
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "title", part.Name)
+		assert.Equal(t, "", part.FileName)
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, "my upload", string(data))
+
+		part, err = mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "file", part.Name)
+		assert.Equal(t, "notes.txt", part.FileName)
+		contentType, _ := part.Headers.Get("Content-Type")
+		assert.Equal(t, "text/plain", contentType)
+		data, err = io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, "line one\r\nline two --xYzZ not quite a boundary", string(data))
+
+		// left unread, skipped by NextPart
+		part, err = mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "empty.txt", part.FileName)
+
+		_, err = mr.NextPart()
+		assert.ErrorIs(t, err, io.EOF)
+	}
+
+	// Test: Body cut short before the closing delimiter
+	r := multipartRequest(t, "--xYzZY\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nabc", 3)
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(part)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Part over the size limit
+	r = multipartRequest(t, "--xYzZY\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n"+strings.Repeat("a", 64)+"\r\n--xYzZY--\r\n", 16)
+	mr, err = r.MultipartReaderWithLimits(MultipartLimits{MaxPartSize: 32})
+	require.NoError(t, err)
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	_, err = io.ReadAll(part)
+	assert.ErrorIs(t, err, ErrPartTooLarge)
+
+	// Test: Part headers over the limit
+	r = multipartRequest(t, "--xYzZY\r\nContent-Disposition: form-data; name=\""+strings.Repeat("a", 64)+"\"\r\n\r\nabc\r\n--xYzZY--\r\n", 16)
+	mr, err = r.MultipartReaderWithLimits(MultipartLimits{MaxPartHeaderBytes: 32})
+	require.NoError(t, err)
+	_, err = mr.NextPart()
+	assert.ErrorIs(t, err, ErrPartHeadTooLarge)
+
+	// Test: Missing boundary
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Type: multipart/form-data\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+	_, err = r.MultipartReader()
+	assert.ErrorIs(t, err, ErrNotMultipart)
+}
+
+func TestParseMultipartForm(t *testing.T) {
+	// Test: Small files stay in memory
+	r := multipartRequest(t, multipartBody, 5)
+	form, err := r.ParseMultipartForm(MultipartLimits{})
+	require.NoError(t, err)
+	defer form.RemoveAll()
+	assert.Equal(t, "my upload", form.Value.Get("title"))
+	require.Len(t, form.File["file"], 2)
+	fh := form.File["file"][0]
+	assert.Equal(t, "notes.txt", fh.FileName)
+	assert.Equal(t, int64(len("line one\r\nline two --xYzZ not quite a boundary")), fh.Size)
+	assert.Empty(t, fh.tmpFile)
+	f, err := fh.Open()
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "line one\r\nline two --xYzZ not quite a boundary", string(data))
+
+	// Test: Large files are spilled to the temp dir
+	dir := t.TempDir()
+	r = multipartRequest(t, multipartBody, 5)
+	form, err = r.ParseMultipartForm(MultipartLimits{MaxMemory: 16, TempDir: dir})
+	require.NoError(t, err)
+	fh = form.File["file"][0]
+	require.NotEmpty(t, fh.tmpFile)
+	f, err = fh.Open()
+	require.NoError(t, err)
+	data, err = io.ReadAll(f)
+	require.NoError(t, err)
+	f.Close()
+	assert.Equal(t, "line one\r\nline two --xYzZ not quite a boundary", string(data))
+	assert.Equal(t, int64(len(data)), fh.Size)
+
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(fh.tmpFile)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,74 @@
+package request
+
+import (
+	"errors"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+)
+
+// maxChunkSizeLineLength caps a chunk-size line including its extensions so
+// a client can't make us buffer an endless line in the middle of the body
+const maxChunkSizeLineLength = 4096
+
+var (
+	ErrRequestLineTooLong = errors.New("request line too long")
+	ErrHeaderTooLarge     = errors.New("header section too large")
+	ErrTooManyHeaders     = errors.New("too many header fields")
+	ErrBodyTooLarge       = errors.New("request body too large")
+)
+
+// Limits bounds how much of a request the parser is willing to hold on to.
+// A zero field falls back to the value in DefaultLimits.
+type Limits struct {
+	// MaxRequestLineLength is the longest request line accepted, not counting the CRLF
+	MaxRequestLineLength int
+	// MaxHeaderBytes bounds the header section, trailer fields count towards it too
+	MaxHeaderBytes int
+	// MaxHeaderCount is the number of field lines accepted, trailers included
+	MaxHeaderCount int
+	// MaxBodySize is the largest decoded body accepted
+	MaxBodySize int64
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineLength: 8 << 10,
+	MaxHeaderBytes:       64 << 10,
+	MaxHeaderCount:       100,
+	MaxBodySize:          10 << 20,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLineLength <= 0 {
+		l.MaxRequestLineLength = DefaultLimits.MaxRequestLineLength
+	}
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = DefaultLimits.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount <= 0 {
+		l.MaxHeaderCount = DefaultLimits.MaxHeaderCount
+	}
+	if l.MaxBodySize <= 0 {
+		l.MaxBodySize = DefaultLimits.MaxBodySize
+	}
+	return l
+}
+
+// parseFieldLines runs the header parser over data while keeping track of the
+// header limits, it is shared by the header section and the trailer section
+func (r *Request) parseFieldLines(h *headers.Headers, data []byte) (int, bool, error) {
+	n, lines, done, err := h.ParseLines(data)
+	if err != nil {
+		return 0, false, err
+	}
+	r.headerBytes += n
+	r.headerCount += lines
+	if r.headerCount > r.limits.MaxHeaderCount {
+		return 0, false, &ParseError{Field: "headers", StatusCode: statusRequestHeaderFieldsTooLarge, Err: ErrTooManyHeaders}
+	}
+	if r.headerBytes > r.limits.MaxHeaderBytes ||
+		!done && r.headerBytes+len(data)-n > r.limits.MaxHeaderBytes {
+		// a field line still incomplete but already over the limit counts too
+		return 0, false, &ParseError{Field: "headers", StatusCode: statusRequestHeaderFieldsTooLarge, Err: ErrHeaderTooLarge}
+	}
+	return n, done, nil
+}
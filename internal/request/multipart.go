@@ -0,0 +1,340 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+)
+
+// MultipartLimits bounds what the multipart parser holds on to. A zero field
+// falls back to the value in DefaultMultipartLimits.
+type MultipartLimits struct {
+	// MaxPartHeaderBytes bounds the header section of a single part
+	MaxPartHeaderBytes int
+	// MaxPartSize bounds the body of a single part
+	MaxPartSize int64
+	// MaxMemory is how many bytes of file parts ParseMultipartForm keeps in
+	// memory, the rest are spilled to files in TempDir
+	MaxMemory int64
+	// TempDir is where large file parts are spilled, os.TempDir() if empty
+	TempDir string
+}
+
+var DefaultMultipartLimits = MultipartLimits{
+	MaxPartHeaderBytes: 8 << 10,
+	MaxPartSize:        10 << 20,
+	MaxMemory:          1 << 20,
+}
+
+func (l MultipartLimits) withDefaults() MultipartLimits {
+	if l.MaxPartHeaderBytes <= 0 {
+		l.MaxPartHeaderBytes = DefaultMultipartLimits.MaxPartHeaderBytes
+	}
+	if l.MaxPartSize <= 0 {
+		l.MaxPartSize = DefaultMultipartLimits.MaxPartSize
+	}
+	if l.MaxMemory <= 0 {
+		l.MaxMemory = DefaultMultipartLimits.MaxMemory
+	}
+	if l.TempDir == "" {
+		l.TempDir = os.TempDir()
+	}
+	return l
+}
+
+// MultipartReader streams the parts of a multipart/form-data body one at a
+// time, nothing but the current part's buffered bytes is held in memory
+type MultipartReader struct {
+	src       io.Reader
+	limits    MultipartLimits
+	delimiter []byte
+	buf       []byte
+	current   *Part
+	done      bool
+}
+
+// Part is a single part of a multipart body, reading it yields the part's
+// body. It is only valid until the next call to NextPart.
+type Part struct {
+	Headers  *headers.Headers
+	Name     string
+	FileName string
+
+	mr   *MultipartReader
+	read int64
+	done bool
+}
+
+// MultipartReader returns a streaming reader over the parts of a
+// multipart/form-data body
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	return r.MultipartReaderWithLimits(DefaultMultipartLimits)
+}
+
+func (r *Request) MultipartReaderWithLimits(limits MultipartLimits) (*MultipartReader, error) {
+	mediaType, params, err := r.Headers.ContentType()
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 {
+		return nil, ErrNotMultipart
+	}
+
+	mr := &MultipartReader{
+		src:       r.Body,
+		limits:    limits.withDefaults(),
+		delimiter: []byte("\r\n--" + boundary),
+		// the first delimiter isn't preceded by a CRLF, pretending it is lets
+		// every delimiter be matched the same way
+		buf: []byte(crlf),
+	}
+	// whatever comes before the first delimiter is a preamble to be skipped,
+	// it is read like a part that is never handed out
+	mr.current = &Part{mr: mr}
+	return mr, nil
+}
+
+// NextPart skips what is left of the current part and returns the next one,
+// io.EOF is returned after the last part
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	if mr.current != nil {
+		if _, err := io.Copy(io.Discard, mr.current); err != nil {
+			return nil, err
+		}
+		mr.current = nil
+	}
+
+	// after a delimiter comes either "--" closing the body, or optional
+	// whitespace and the CRLF starting the part headers
+	for {
+		if bytes.HasPrefix(mr.buf, []byte("--")) {
+			mr.done = true
+			return nil, io.EOF
+		}
+		if idx := bytes.Index(mr.buf, []byte(crlf)); idx != -1 {
+			if len(bytes.Trim(mr.buf[:idx], " \t")) != 0 {
+				return nil, ErrMalformedPart
+			}
+			mr.buf = mr.buf[idx+2:]
+			break
+		}
+		if len(mr.buf) > 2*len(mr.delimiter) {
+			return nil, ErrMalformedPart
+		}
+		if err := mr.fill(); err != nil {
+			return nil, err
+		}
+	}
+
+	part := &Part{
+		Headers: headers.NewHeaders(),
+		mr:      mr,
+	}
+	headerBytes := 0
+	for {
+		n, _, done, err := part.Headers.ParseLines(mr.buf)
+		if err != nil {
+			return nil, ErrMalformedPart
+		}
+		mr.buf = mr.buf[n:]
+		headerBytes += n
+		if headerBytes+len(mr.buf) > mr.limits.MaxPartHeaderBytes && !done {
+			return nil, ErrPartHeadTooLarge
+		}
+		if done {
+			break
+		}
+		if err := mr.fill(); err != nil {
+			return nil, err
+		}
+	}
+
+	if disposition, ok := part.Headers.Get("Content-Disposition"); ok {
+		dispositionType, params, err := headers.ParseMediaType(disposition)
+		if err != nil || dispositionType != "form-data" {
+			return nil, ErrMalformedPart
+		}
+		part.Name = params["name"]
+		part.FileName = params["filename"]
+	}
+	mr.current = part
+	return part, nil
+}
+
+// fill reads more of the body into the buffer, running out of body before
+// the closing delimiter is an error
+func (mr *MultipartReader) fill() error {
+	if len(mr.buf) == cap(mr.buf) {
+		newBuf := make([]byte, len(mr.buf), max(2*cap(mr.buf), bufferSize))
+		copy(newBuf, mr.buf)
+		mr.buf = newBuf
+	}
+	n, err := mr.src.Read(mr.buf[len(mr.buf):cap(mr.buf)])
+	mr.buf = mr.buf[:len(mr.buf)+n]
+	if n > 0 {
+		return nil
+	}
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (p *Part) Read(b []byte) (int, error) {
+	if p.done {
+		return 0, io.EOF
+	}
+	mr := p.mr
+	for {
+		idx := bytes.Index(mr.buf, mr.delimiter)
+		if idx == 0 {
+			mr.buf = mr.buf[len(mr.delimiter):]
+			p.done = true
+			return 0, io.EOF
+		}
+
+		// bytes that could still turn out to be the start of the delimiter
+		// have to stay in the buffer until more data arrives
+		safe := idx
+		if idx == -1 {
+			safe = max(len(mr.buf)-len(mr.delimiter)+1, 0)
+		}
+		if safe > 0 {
+			n := copy(b, mr.buf[:safe])
+			p.read += int64(n)
+			if p.read > mr.limits.MaxPartSize {
+				return 0, ErrPartTooLarge
+			}
+			// keep the buffer from creeping forward through its backing array
+			mr.buf = append(mr.buf[:0], mr.buf[n:]...)
+			return n, nil
+		}
+		if err := mr.fill(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// MultipartForm is a fully parsed multipart/form-data body
+type MultipartForm struct {
+	Value Values
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes a file part, its content is either kept in memory or
+// spilled to a temporary file
+type FileHeader struct {
+	FileName string
+	Headers  *headers.Headers
+	Size     int64
+
+	content []byte
+	tmpFile string
+}
+
+// Open returns the content of the file part
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.tmpFile != "" {
+		return os.Open(fh.tmpFile)
+	}
+	return io.NopCloser(bytes.NewReader(fh.content)), nil
+}
+
+// RemoveAll deletes the temporary files created for the form
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tmpFile != "" {
+				errs = append(errs, os.Remove(fh.tmpFile))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseMultipartForm reads the whole multipart/form-data body. Field values
+// and small files are kept in memory, once limits.MaxMemory is used up files
+// are written to limits.TempDir. The caller should call RemoveAll on the form
+// when done with it.
+func (r *Request) ParseMultipartForm(limits MultipartLimits) (*MultipartForm, error) {
+	mr, err := r.MultipartReaderWithLimits(limits)
+	if err != nil {
+		return nil, err
+	}
+	limits = mr.limits
+
+	form := &MultipartForm{
+		Value: Values{},
+		File:  map[string][]*FileHeader{},
+	}
+	memoryLeft := limits.MaxMemory
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return form, nil
+		}
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		n, err := io.CopyN(&buf, part, memoryLeft+1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			form.RemoveAll()
+			return nil, err
+		}
+
+		if part.FileName == "" {
+			if n > memoryLeft {
+				form.RemoveAll()
+				return nil, ErrPartTooLarge
+			}
+			memoryLeft -= n
+			form.Value[part.Name] = append(form.Value[part.Name], buf.String())
+			continue
+		}
+
+		fh := &FileHeader{
+			FileName: part.FileName,
+			Headers:  part.Headers,
+		}
+		form.File[part.Name] = append(form.File[part.Name], fh)
+		if n <= memoryLeft {
+			memoryLeft -= n
+			fh.content = buf.Bytes()
+			fh.Size = n
+			continue
+		}
+
+		// too big for memory, spill what was read so far and the rest of the
+		// part to a temporary file
+		size, tmpFile, err := spill(limits.TempDir, &buf, part)
+		fh.tmpFile = tmpFile
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+		fh.Size = size
+	}
+}
+
+func spill(dir string, head *bytes.Buffer, rest io.Reader) (int64, string, error) {
+	f, err := os.CreateTemp(dir, "multipart-")
+	if err != nil {
+		return 0, "", err
+	}
+	size, err := io.Copy(f, io.MultiReader(head, rest))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return size, f.Name(), err
+}
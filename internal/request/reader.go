@@ -0,0 +1,114 @@
+package request
+
+import (
+	"errors"
+	"io"
+)
+
+// Reader reads successive requests off a single connection. Bytes read past
+// the end of one request are kept for the next, so keep-alive and pipelined
+// requests are parsed in order.
+type Reader struct {
+	src    connBuffer
+	limits Limits
+	last   *Request
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return NewReaderWithLimits(reader, DefaultLimits)
+}
+
+func NewReaderWithLimits(reader io.Reader, limits Limits) *Reader {
+	return &Reader{
+		src:    connBuffer{reader: reader},
+		limits: limits.withDefaults(),
+	}
+}
+
+// Release returns the Reader's buffer to the pool once the connection is done
+// with, the Reader and the last request's body must not be used afterwards
+func (rr *Reader) Release() {
+	rr.src.release()
+	rr.last = nil
+}
+
+// Discard reads and drops whatever the handler left unread of the last
+// request's body. ErrBodyNotSent is returned when the client is still waiting
+// for 100 Continue, the connection can't carry another request then.
+func (rr *Reader) Discard() error {
+	if rr.last == nil {
+		return nil
+	}
+	if err := rr.last.body.discard(); err != nil {
+		return err
+	}
+	rr.last = nil
+	return nil
+}
+
+// Wait blocks until the next request starts to arrive, after discarding what
+// is left of the last one like ReadRequest does. io.EOF is returned when the
+// connection is closed cleanly first.
+func (rr *Reader) Wait() error {
+	if err := rr.Discard(); err != nil {
+		return err
+	}
+	for len(rr.src.buffered()) == 0 {
+		if err := rr.src.fill(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRequest parses the next request on the connection and returns as soon
+// as its header section is complete. The previous request's body is
+// discarded first, see Discard. io.EOF is returned when the connection is
+// closed cleanly between two requests.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	if err := rr.Discard(); err != nil {
+		return nil, err
+	}
+
+	req := &Request{
+		state:  requestStateInitialized,
+		limits: rr.limits,
+	}
+	// the usual header section fits in the request's own field storage
+	req.header.UseBuffer(req.fields[:])
+	req.Headers = &req.header
+	src := &rr.src
+
+	for !req.headersParsed() {
+		numBytesParsed, err := req.parse(src.buffered())
+		if err != nil {
+			return nil, err
+		}
+		src.consume(numBytesParsed)
+		if req.headersParsed() {
+			break
+		}
+
+		if err := src.fill(); err != nil {
+			if errors.Is(err, io.EOF) {
+				if len(src.buffered()) == 0 && req.state == requestStateInitialized {
+					return nil, io.EOF
+				}
+				return nil, &ParseError{
+					Offset:     req.offset + int64(len(src.buffered())),
+					Field:      "request",
+					StatusCode: statusBadRequest,
+					Err:        ErrIncompleteRequest,
+				}
+			}
+			return nil, err
+		}
+	}
+	req.body = body{
+		req: req,
+		src: src,
+	}
+	req.Body = &req.body
+	rr.last = req
+	return req, nil
+}
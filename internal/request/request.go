@@ -2,19 +2,15 @@ package request
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
-	"strconv"
+	"slices"
 	"strings"
 
 	"github.com/seandisero/httpfromtcp/internal/headers"
 )
 
-const (
-	crlf       = "\r\n"
-	bufferSize = 8
-)
+const crlf = "\r\n"
 
 type RequestState int
 
@@ -22,21 +18,43 @@ const (
 	requestStateInitialized RequestState = iota
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingChunkDataEnd
+	requestStateParsingTrailers
 	requestStateDone
 )
 
 type Request struct {
-	RequestLine    RequestLine
-	Headers        headers.Headers
-	Body           []byte
+	RequestLine RequestLine
+	Headers     *headers.Headers
+	// Trailers is only set once a chunked Body has been read to EOF
+	Trailers *headers.Headers
+	// Body streams the message body from the connection as it is read
+	Body io.ReadCloser
+
+	body           body
+	header         headers.Headers
+	fields         [8]headers.Field
+	query          map[string][]string
+	cookies        []Cookie
 	state          RequestState
-	bodyLengthRead int
+	limits         Limits
+	continueFunc   func() error
+	offset         int64
+	headerBytes    int
+	headerCount    int
+	contentLength  int64
+	bodyLengthRead int64
+	chunkRemaining int64
 }
 
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
+	// Target is RequestTarget parsed into its form, path and query
+	Target Target
 }
 
 type chunkReader struct {
@@ -64,6 +82,15 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.state {
 	case requestStateInitialized:
+		if r.offset == 0 && bytes.HasPrefix(data, []byte(crlf)) {
+			// a client may send an extra CRLF after a body, it is ignored
+			// instead of being taken for an empty request line
+			return len(crlf), nil
+		}
+		if lineLen := bytes.Index(data, []byte(crlf)); lineLen > r.limits.MaxRequestLineLength ||
+			lineLen == -1 && len(data) > r.limits.MaxRequestLineLength+1 {
+			return 0, &ParseError{Field: "request-line", StatusCode: statusURITooLong, Err: ErrRequestLineTooLong}
+		}
 		req, idx, err := parseRequestLine(data)
 		if err != nil {
 			return 0, err
@@ -72,54 +99,121 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			// need more data
 			return 0, nil
 		}
-		r.RequestLine = *req
+		r.RequestLine = req
 		r.state = requestStateParsingHeaders
 		return idx, nil
 	case requestStateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		n, done, err := r.parseFieldLines(r.Headers, data)
 		if err != nil {
 			return 0, err
 		}
 		if done {
-			r.state = requestStateParsingBody
+			if _, ok := r.Headers.Host(); !ok && r.RequestLine.HttpVersion != "1.0" {
+				// Host is only optional before HTTP/1.1
+				return 0, &ParseError{Field: "host", StatusCode: statusBadRequest, Err: ErrMissingHost}
+			}
+			if r.Headers.Count("Host") > 1 {
+				// RFC 9112 section 3.2, two hosts can't both be the target
+				return 0, &ParseError{Field: "host", StatusCode: statusBadRequest, Err: ErrDuplicateHost}
+			}
+			if expect, ok := r.Headers.Get("Expect"); ok && r.RequestLine.HttpVersion != "1.0" &&
+				!strings.EqualFold(expect, "100-continue") {
+				return 0, &ParseError{Field: "expect", StatusCode: statusExpectationFailed, Err: ErrUnsupportedExpectation}
+			}
+			if err := r.startBody(); err != nil {
+				return 0, err
+			}
 		}
 		return n, nil
-	case requestStateParsingBody:
-		contentLenStr, ok := r.Headers.Get("Content-Length")
-		if !ok {
-			// assume that if no content-length header is present, there is no body
-			r.state = requestStateDone
-			return len(data), nil
-		}
-		contentLen, err := strconv.Atoi(contentLenStr)
-		if err != nil {
-			return 0, fmt.Errorf("malformed Content-Length: %s", err)
-		}
-		r.Body = append(r.Body, data...)
-		r.bodyLengthRead += len(data)
-		if r.bodyLengthRead > contentLen {
-			return 0, fmt.Errorf("Content-Length too large")
+	default:
+		return 0, fmt.Errorf("headers already parsed")
+	}
+}
+
+// startBody picks the body state from the framing headers once the header
+// section is complete. The framing rules follow RFC 9112 section 6 strictly,
+// anything ambiguous is refused rather than guessed at so that a proxy in
+// front of us can't be made to disagree about where this request ends.
+func (r *Request) startBody() error {
+	_, hasTE := r.Headers.Get("Transfer-Encoding")
+	_, hasCL := r.Headers.Get("Content-Length")
+	if hasTE && hasCL {
+		return &ParseError{Field: "transfer-encoding", StatusCode: statusBadRequest, Err: ErrConflictingFraming}
+	}
+	if hasTE && r.RequestLine.HttpVersion == "1.0" {
+		// RFC 9112 section 6.1, the framing of an HTTP/1.0 message with
+		// Transfer-Encoding is faulty. A 1.0 proxy in front of us would
+		// forward the body as is and both could read a different request
+		// out of it.
+		return &ParseError{Field: "transfer-encoding", StatusCode: statusBadRequest, Err: ErrTransferEncoding10}
+	}
+	if hasTE {
+		if err := checkTransferEncoding(r.Headers); err != nil {
+			return err
 		}
-		if r.bodyLengthRead == contentLen {
-			r.state = requestStateDone
+		r.state = requestStateParsingChunkSize
+		return nil
+	}
+	if !hasCL {
+		// assume that if no content-length header is present, there is no body
+		r.state = requestStateDone
+		return nil
+	}
+	contentLen, err := r.Headers.ContentLength()
+	if err != nil {
+		return &ParseError{Field: "content-length", StatusCode: statusBadRequest, Err: err}
+	}
+	if contentLen > r.limits.MaxBodySize {
+		return &ParseError{Field: "content-length", StatusCode: statusContentTooLarge, Err: ErrBodyTooLarge}
+	}
+	r.contentLength = contentLen
+	if contentLen == 0 {
+		r.state = requestStateDone
+		return nil
+	}
+	r.state = requestStateParsingBody
+	return nil
+}
+
+// checkTransferEncoding makes sure chunked is the one and only coding, it is
+// the only coding the parser knows how to decode
+func checkTransferEncoding(h *headers.Headers) error {
+	codings, err := h.TransferEncoding()
+	if err != nil {
+		return &ParseError{Field: "transfer-encoding", StatusCode: statusBadRequest, Err: ErrInvalidTransferEncoding}
+	}
+	unsupported := false
+	for i, coding := range codings {
+		switch {
+		case coding == "chunked" && i != len(codings)-1:
+			// chunked applied before another coding, or applied twice
+			return &ParseError{Field: "transfer-encoding", StatusCode: statusBadRequest, Err: ErrInvalidTransferEncoding}
+		case coding != "chunked":
+			unsupported = true
 		}
-		return len(data), nil
-	case requestStateDone:
-		return 0, fmt.Errorf("parsing is done")
-	default:
-		return 0, fmt.Errorf("unknown state")
 	}
+	if unsupported {
+		return &ParseError{Field: "transfer-encoding", StatusCode: statusNotImplemented, Err: ErrUnsupportedTransferCoding}
+	}
+	return nil
+}
+
+// headersParsed reports whether the request line and headers have been parsed,
+// at which point the rest of the message belongs to Body
+func (r *Request) headersParsed() bool {
+	return r.state != requestStateInitialized && r.state != requestStateParsingHeaders
 }
 
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
-	for r.state != requestStateDone {
+	for !r.headersParsed() {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
-			return 0, err
+			return 0, r.located(err)
 		}
 
 		totalBytesParsed += n
+		r.offset += int64(n)
 		if n == 0 {
 			break
 		}
@@ -127,82 +221,155 @@ func (r *Request) parse(data []byte) (int, error) {
 	return totalBytesParsed, nil
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
-	req := &Request{
-		Headers: headers.NewHeaders(),
-		Body:    make([]byte, 0),
-		state:   requestStateInitialized,
-	}
-
-	for req.state != requestStateDone {
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
-		}
+// Path returns the percent-decoded path of the request target
+func (r *Request) Path() string {
+	return r.RequestLine.Target.Path
+}
 
-		numBytesRead, err := reader.Read(buf[readToIndex:])
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				if req.state != requestStateDone {
-					return nil, fmt.Errorf("incompete request, in state %d, read n bytes on EOF: %d", req.state, numBytesRead)
-				}
-				break
-			}
-			return nil, err
-		}
-		readToIndex += numBytesRead
+// RawQuery returns the query of the request target without the '?', as sent
+func (r *Request) RawQuery() string {
+	return r.RequestLine.Target.RawQuery
+}
 
-		numBytesParsed, err := req.parse(buf[:readToIndex])
-		if err != nil {
-			return nil, err
-		}
+// Query returns the first value of the query parameter key, or "" if the
+// parameter isn't present
+func (r *Request) Query(key string) string {
+	values := r.QueryValues(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
 
-		copy(buf, buf[numBytesParsed:])
-		readToIndex -= numBytesParsed
+// QueryValues returns every value of the query parameter key
+func (r *Request) QueryValues(key string) []string {
+	if r.query == nil {
+		// the escapes were validated with the request line
+		r.query, _ = parseQuery(r.RequestLine.Target.RawQuery)
+	}
+	return r.query[key]
+}
+
+// ExpectsContinue reports whether the client is waiting for a 100 Continue
+// before it sends the body. HTTP/1.0 clients can't expect it.
+func (r *Request) ExpectsContinue() bool {
+	expect, ok := r.Headers.Get("Expect")
+	return ok && r.RequestLine.HttpVersion != "1.0" && r.state != requestStateDone &&
+		strings.EqualFold(expect, "100-continue")
+}
+
+// OnContinue registers fn to send the 100 Continue interim response. It is
+// called once, the first time reading Body has to wait on the connection,
+// so a handler that answers without reading the body never triggers it.
+func (r *Request) OnContinue(fn func() error) {
+	if r.ExpectsContinue() {
+		r.continueFunc = fn
+	}
+}
+
+// KeepAlive reports whether the client wants the connection kept open after
+// this request. HTTP/1.1 connections persist unless the client sends
+// "Connection: close", HTTP/1.0 ones only with "Connection: keep-alive"
+func (r *Request) KeepAlive() bool {
+	tokens := r.Headers.Connection()
+	if slices.Contains(tokens, "close") {
+		return false
+	}
+	if r.RequestLine.HttpVersion == "1.0" {
+		return slices.Contains(tokens, "keep-alive")
 	}
+	return true
+}
+
+// ReadBody reads the whole body into memory, it is meant for small payloads
+// and handlers that need the full body at once. Large bodies should be
+// streamed from Body instead.
+func (r *Request) ReadBody() ([]byte, error) {
+	return io.ReadAll(r.Body)
+}
+
+// RequestFromReader parses the request line and headers from reader and
+// returns as soon as the header section is complete. The body is left on
+// the reader and is pulled on demand through Request.Body.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return RequestFromReaderWithLimits(reader, DefaultLimits)
+}
+
+// RequestFromReaderWithLimits is RequestFromReader with caller supplied
+// parser limits, violations are reported as a *ParseError wrapping
+// ErrRequestLineTooLong, ErrHeaderTooLarge, ErrTooManyHeaders or ErrBodyTooLarge
+func RequestFromReaderWithLimits(reader io.Reader, limits Limits) (*Request, error) {
+	rr := NewReaderWithLimits(reader, limits)
+	req, err := rr.ReadRequest()
+	if err != nil {
+		rr.Release()
+		return nil, err
+	}
+	// nobody else reads from this buffer, it can go back to the pool as soon
+	// as the body is done with it
+	req.body.owner = rr
 	return req, nil
 }
 
-func parseRequestLine(data []byte) (*RequestLine, int, error) {
+func parseRequestLine(data []byte) (RequestLine, int, error) {
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
-		return nil, 0, nil
+		return RequestLine{}, 0, nil
 	}
 	requestLineText := string(data[:idx])
 	reqLine, err := requestLineFromString(requestLineText)
 	if err != nil {
-		return nil, 0, err
+		return RequestLine{}, 0, err
 	}
 	return reqLine, idx + 2, nil
 }
 
-func requestLineFromString(line string) (*RequestLine, error) {
-	split := strings.Split(line, " ")
-	if len(split) < 3 {
-		return nil, fmt.Errorf("wrong number of splits in request line")
+var supportedVersions = []string{"1.0", "1.1"}
+
+var knownMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH"}
+
+func requestLineFromString(line string) (RequestLine, error) {
+	method, rest, _ := strings.Cut(line, " ")
+	target, version, ok := strings.Cut(rest, " ")
+	if !ok || strings.IndexByte(version, ' ') != -1 {
+		return RequestLine{}, &ParseError{Field: "request-line", StatusCode: statusBadRequest, Err: ErrMalformedRequestLine}
 	}
+	targetOffset := int64(len(method) + 1)
+	versionOffset := targetOffset + int64(len(target)+1)
 
-	if strings.ToUpper(split[0]) != split[0] {
-		return nil, fmt.Errorf("method must be all uppercase")
+	if strings.ToUpper(method) != method || method == "" {
+		return RequestLine{}, &ParseError{Field: "method", StatusCode: statusBadRequest, Err: ErrInvalidMethod}
+	}
+	if !slices.Contains(knownMethods, method) {
+		return RequestLine{}, &ParseError{Field: "method", StatusCode: statusNotImplemented, Err: ErrUnknownMethod}
 	}
 
-	versionSplit := strings.Split(split[2], "/")
-	if len(versionSplit) != 2 {
-		return nil, fmt.Errorf("malformed request line")
+	if target == "" {
+		return RequestLine{}, &ParseError{Offset: targetOffset, Field: "request-target", StatusCode: statusBadRequest, Err: ErrMalformedRequestLine}
+	}
+	parsedTarget, err := parseTarget(method, target)
+	if err != nil {
+		return RequestLine{}, &ParseError{Offset: targetOffset, Field: "request-target", StatusCode: statusBadRequest, Err: err}
 	}
 
-	if versionSplit[1] != "1.1" {
-		return nil, fmt.Errorf("wrong http version")
+	versionNumber, ok := strings.CutPrefix(version, "HTTP/")
+	if !ok || len(versionNumber) != 3 || !isDigit(versionNumber[0]) || versionNumber[1] != '.' || !isDigit(versionNumber[2]) {
+		return RequestLine{}, &ParseError{Offset: versionOffset, Field: "version", StatusCode: statusBadRequest, Err: ErrMalformedVersion}
+	}
+	if !slices.Contains(supportedVersions, versionNumber) {
+		return RequestLine{}, &ParseError{Offset: versionOffset, Field: "version", StatusCode: statusHTTPVersionNotSupported, Err: ErrUnsupportedVersion}
 	}
 
 	reqLine := RequestLine{
-		HttpVersion:   versionSplit[1],
-		RequestTarget: split[1],
-		Method:        split[0],
+		HttpVersion:   versionNumber,
+		RequestTarget: target,
+		Method:        method,
+		Target:        parsedTarget,
 	}
 
-	return &reqLine, nil
+	return reqLine, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
 }
@@ -1,13 +1,21 @@
 package request
 
 import (
+	"fmt"
+	"io"
 	"strings"
 	"testing"
 
+	"github.com/seandisero/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+func headerValue(h *headers.Headers, key string) string {
+	v, _ := h.Get(key)
+	return v
+}
+
 func TestRequestLineParse(t *testing.T) {
 	reader := &chunkReader{
 		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
@@ -71,9 +79,9 @@ func TestRequestLineParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", headerValue(r.Headers, "host"))
+	assert.Equal(t, "curl/7.81.0", headerValue(r.Headers, "user-agent"))
+	assert.Equal(t, "*/*", headerValue(r.Headers, "accept"))
 
 }
 
@@ -89,9 +97,11 @@ func TestRequestBody(t *testing.T) {
 	}
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
-	assert.Equal(t, "13", r.Headers["content-length"])
+	assert.Equal(t, "13", headerValue(r.Headers, "content-length"))
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -103,5 +113,719 @@ func TestRequestBody(t *testing.T) {
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+
+	// Test: Body is streamed in pieces as it is read
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 11\r\n" +
+			"\r\n" +
+			"hello world",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	p := make([]byte, 5)
+	n, err := io.ReadFull(r.Body, p)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(p[:n]))
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, " world", string(body))
+	n, err = r.Body.Read(p)
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Reading a closed body
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello"))
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(p)
+	require.Error(t, err)
+
+	// Test: Malformed Content-Length
+	r, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: five\r\n" +
+		"\r\n" +
+		"hello"))
+	require.Error(t, err)
+	require.Nil(t, r)
+}
+
+func TestRequestChunkedBody(t *testing.T) {
+	// Test: Chunked body with extensions and trailers
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7;name=value\r\n world!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+	assert.Equal(t, "abc123", headerValue(r.Trailers, "x-checksum"))
+
+	// Test: Chunked body with hex sizes and no trailers
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"a\r\n0123456789\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(body))
+
+	// Test: Invalid chunk size
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"zz\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = r.ReadBody()
 	require.Error(t, err)
+
+	// Test: Chunk data longer than chunk size
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"3\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+
+	// Test: Missing last chunk
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n"))
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineLength: 32,
+		MaxHeaderBytes:       64,
+		MaxHeaderCount:       3,
+		MaxBodySize:          16,
+	}
+
+	// Test: Request within limits
+	r, err := RequestFromReaderWithLimits(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello"), limits)
+	require.NoError(t, err)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Request line too long
+	reader := &chunkReader{
+		data:            "GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReaderWithLimits(reader, limits)
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+	require.Nil(t, r)
+
+	// Test: Request line that never ends
+	r, err = RequestFromReaderWithLimits(strings.NewReader("GET /"+strings.Repeat("a", 1024)), limits)
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+	require.Nil(t, r)
+
+	// Test: Header section too large
+	r, err = RequestFromReaderWithLimits(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Long: "+strings.Repeat("a", 64)+"\r\n\r\n"), limits)
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+	require.Nil(t, r)
+
+	// Test: Too many headers
+	r, err = RequestFromReaderWithLimits(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n"), limits)
+	require.ErrorIs(t, err, ErrTooManyHeaders)
+	require.Nil(t, r)
+
+	// Test: Content-Length over the body limit
+	r, err = RequestFromReaderWithLimits(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 17\r\n\r\n"), limits)
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+	require.Nil(t, r)
+
+	// Test: Chunked body growing over the body limit
+	r, err = RequestFromReaderWithLimits(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"a\r\n0123456789\r\na\r\n0123456789\r\n0\r\n\r\n"), limits)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Zero limits fall back to the defaults
+	r, err = RequestFromReaderWithLimits(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"), Limits{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLimits, r.limits)
+}
+
+func TestRequestParseErrors(t *testing.T) {
+	// Test: Unsupported http version
+	_, err := RequestFromReader(strings.NewReader("GET / HTTP/2.0\r\nHost: localhost:42069\r\n\r\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	assert.Equal(t, 505, perr.StatusCode)
+	assert.Equal(t, "version", perr.Field)
+	assert.Equal(t, int64(6), perr.Offset)
+
+	// Test: Malformed http version
+	_, err = RequestFromReader(strings.NewReader("GET / HTTX/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrMalformedVersion)
+	assert.Equal(t, 400, perr.StatusCode)
+
+	// Test: Unknown method
+	_, err = RequestFromReader(strings.NewReader("BREW /pot HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrUnknownMethod)
+	assert.Equal(t, 501, perr.StatusCode)
+	assert.Equal(t, "method", perr.Field)
+
+	// Test: Lowercase method
+	_, err = RequestFromReader(strings.NewReader("get / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrInvalidMethod)
+	assert.Equal(t, 400, perr.StatusCode)
+
+	// Test: Invalid header reports its offset in the request
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nBad Name: x\r\n\r\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, headers.ErrInvalidFieldName)
+	assert.Equal(t, 400, perr.StatusCode)
+	assert.Equal(t, int64(len("GET / HTTP/1.1\r\nHost: localhost:42069\r\nBad")), perr.Offset)
+
+	// Test: Invalid Content-Length
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: -1\r\n\r\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrInvalidContentLength)
+	assert.Equal(t, "content-length", perr.Field)
+
+	// Test: Malformed chunk reports its offset in the request
+	head := "POST / HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n"
+	r, err := RequestFromReader(strings.NewReader(head + "5\r\nhello\r\nxyz\r\n"))
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrMalformedChunk)
+	assert.Equal(t, int64(len(head)+len("5\r\nhello\r\n")), perr.Offset)
+
+	// Test: Incomplete request
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: local"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrIncompleteRequest)
+}
+
+func TestRequestTarget(t *testing.T) {
+	// Test: Origin-form with a decoded path and query
+	r, err := RequestFromReader(strings.NewReader("GET /caf%C3%A9/menu?item=latte&item=mocha&size=large+cup&empty HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, r.RequestLine.Target.Form)
+	assert.Equal(t, "/café/menu", r.Path())
+	assert.Equal(t, "/caf%C3%A9/menu", r.RequestLine.Target.RawPath)
+	assert.Equal(t, "item=latte&item=mocha&size=large+cup&empty", r.RawQuery())
+	assert.Equal(t, []string{"latte", "mocha"}, r.QueryValues("item"))
+	assert.Equal(t, "latte", r.Query("item"))
+	assert.Equal(t, "large cup", r.Query("size"))
+	assert.Equal(t, []string{""}, r.QueryValues("empty"))
+	assert.Nil(t, r.QueryValues("missing"))
+	assert.Equal(t, []string{"latte", "mocha"}, r.RequestLine.Target.QueryValues("item"))
+	assert.Nil(t, r.RequestLine.Target.QueryValues("missing"))
+
+	// Test: Absolute-form
+	r, err = RequestFromReader(strings.NewReader("GET http://localhost:42069?x=1 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, r.RequestLine.Target.Form)
+	assert.Equal(t, "http", r.RequestLine.Target.Scheme)
+	assert.Equal(t, "localhost:42069", r.RequestLine.Target.Authority)
+	assert.Equal(t, "/", r.Path())
+	assert.Equal(t, "1", r.Query("x"))
+
+	// Test: Authority-form
+	r, err = RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, r.RequestLine.Target.Form)
+	assert.Equal(t, "example.com:443", r.RequestLine.Target.Authority)
+
+	// Test: Asterisk-form
+	r, err = RequestFromReader(strings.NewReader("OPTIONS * HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, r.RequestLine.Target.Form)
+
+	// Test: Asterisk-form is only allowed for OPTIONS
+	_, err = RequestFromReader(strings.NewReader("GET * HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: Bad percent-encoding is a bad request
+	_, err = RequestFromReader(strings.NewReader("GET /100%zz HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+	assert.Equal(t, 400, perr.StatusCode)
+	assert.Equal(t, "request-target", perr.Field)
+
+	// Test: Truncated percent-encoding in the query
+	_, err = RequestFromReader(strings.NewReader("GET /?q=%4 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+
+	// Test: Relative target
+	_, err = RequestFromReader(strings.NewReader("GET coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+}
+
+func TestRequestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 without a Host header
+	r, err := RequestFromReader(strings.NewReader("GET /health HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.False(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 asking for keep-alive
+	r, err = RequestFromReader(strings.NewReader("GET /health HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: HTTP/1.1 is persistent by default
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: HTTP/1.1 closing the connection
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nConnection: foo, close\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	// Test: HTTP/1.1 requires a Host header
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nAccept: */*\r\n\r\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrMissingHost)
+	assert.Equal(t, 400, perr.StatusCode)
+
+	// Test: Repeated Host fields are refused, not joined
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrDuplicateHost)
+	assert.Equal(t, 400, perr.StatusCode)
+
+	// Test: HTTP/2.0 over plaintext is not supported
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/2.0\r\nHost: localhost:42069\r\n\r\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, 505, perr.StatusCode)
+}
+
+func TestRequestSmuggling(t *testing.T) {
+	// Test: Known smuggling payloads are all refused, the ones in the head
+	// before the handler sees them and the ones in a chunked body as it is read
+	corpus := []struct {
+		name   string
+		data   string
+		inBody bool
+		err    error
+		status int
+	}{
+		{
+			name:   "CL.TE both framing headers",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 13\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nSMUGGLED",
+			err:    ErrConflictingFraming,
+			status: 400,
+		},
+		{
+			name:   "TE.CL both framing headers",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n",
+			err:    ErrConflictingFraming,
+			status: 400,
+		},
+		{
+			name:   "differing duplicate Content-Length",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 10\r\n\r\nhellohello",
+			err:    ErrInvalidContentLength,
+			status: 400,
+		},
+		{
+			name:   "differing Content-Length list",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5, 10\r\n\r\nhellohello",
+			err:    ErrInvalidContentLength,
+			status: 400,
+		},
+		{
+			name:   "signed Content-Length",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +5\r\n\r\nhello",
+			err:    ErrInvalidContentLength,
+			status: 400,
+		},
+		{
+			name:   "hex Content-Length",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0x5\r\n\r\nhello",
+			err:    ErrInvalidContentLength,
+			status: 400,
+		},
+		{
+			name:   "unknown transfer coding",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+			err:    ErrUnsupportedTransferCoding,
+			status: 501,
+		},
+		{
+			name:   "obfuscated transfer coding",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n",
+			err:    ErrUnsupportedTransferCoding,
+			status: 501,
+		},
+		{
+			name:   "chunked not the final coding",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n",
+			err:    ErrInvalidTransferEncoding,
+			status: 400,
+		},
+		{
+			name:   "chunked applied twice",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			err:    ErrInvalidTransferEncoding,
+			status: 400,
+		},
+		{
+			name:   "empty transfer coding",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked,\r\n\r\n0\r\n\r\n",
+			err:    ErrInvalidTransferEncoding,
+			status: 400,
+		},
+		{
+			name:   "space before the colon",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\nContent-Length: 5\r\n\r\nhello",
+			err:    headers.ErrInvalidFieldName,
+			status: 400,
+		},
+		{
+			name:   "tab before the colon",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nContent-Length\t: 5\r\n\r\nhello",
+			err:    headers.ErrInvalidFieldName,
+			status: 400,
+		},
+		{
+			name:   "obs-fold continuation line",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nX-Ignore: x\r\n Transfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\nhello",
+			err:    headers.ErrMalformedFieldLine,
+			status: 400,
+		},
+		{
+			name:   "bare LF inside a field line",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nX-Ignore: x\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\nhello",
+			err:    headers.ErrMalformedFieldLine,
+			status: 400,
+		},
+		{
+			name: "chunked HTTP/1.0 request kept alive",
+			data: "POST /one HTTP/1.0\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n" +
+				"GET /smuggled HTTP/1.0\r\n\r\n",
+			err:    ErrTransferEncoding10,
+			status: 400,
+		},
+		{
+			name:   "bare LF in a chunk extension",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5;a\nX\r\nhello\r\n0\r\n\r\n",
+			inBody: true,
+			err:    ErrMalformedChunk,
+			status: 400,
+		},
+		{
+			name:   "bare CR in a chunk extension",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5;a\rb\r\nhello\r\n0\r\n\r\n",
+			inBody: true,
+			err:    ErrMalformedChunk,
+			status: 400,
+		},
+		{
+			name:   "NUL in a chunk extension",
+			data:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5;\x00\r\nhello\r\n0\r\n\r\n",
+			inBody: true,
+			err:    ErrMalformedChunk,
+			status: 400,
+		},
+	}
+
+	for _, tc := range corpus {
+		r, err := RequestFromReader(strings.NewReader(tc.data))
+		if tc.inBody {
+			require.NoError(t, err, tc.name)
+			_, err = r.ReadBody()
+		} else {
+			require.Nil(t, r, tc.name)
+		}
+		require.Error(t, err, tc.name)
+		assert.ErrorIs(t, err, tc.err, tc.name)
+		var perr *ParseError
+		require.ErrorAs(t, err, &perr, tc.name)
+		assert.Equal(t, tc.status, perr.StatusCode, tc.name)
+	}
+
+	// Test: Identical duplicate Content-Length values are folded into one
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Well formed chunk extensions are skipped
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5;name=\"a b\";\tx\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Transfer coding names are case insensitive
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: Chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
+
+// continueReader hands out the head of a request and only releases the body
+// once continued has been set, like a client waiting on 100 Continue
+type continueReader struct {
+	head      string
+	body      string
+	continued bool
+	pos       int
+}
+
+func (cr *continueReader) Read(p []byte) (int, error) {
+	if cr.pos < len(cr.head) {
+		n := copy(p, cr.head[cr.pos:])
+		cr.pos += n
+		return n, nil
+	}
+	if !cr.continued {
+		return 0, fmt.Errorf("body read before 100 Continue was sent")
+	}
+	if cr.pos-len(cr.head) >= len(cr.body) {
+		return 0, io.EOF
+	}
+	n := copy(p, cr.body[cr.pos-len(cr.head):])
+	cr.pos += n
+	return n, nil
+}
+
+func TestRequestExpectContinue(t *testing.T) {
+	// Test: 100 Continue is sent before the body is pulled from the connection
+	reader := &continueReader{
+		head: "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n",
+		body: "hello",
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+	sent := 0
+	r.OnContinue(func() error {
+		sent++
+		reader.continued = true
+		return nil
+	})
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, 1, sent)
+
+	// Test: A handler that never reads the body never sends 100 Continue
+	reader = &continueReader{
+		head: "POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n",
+		body: "hello",
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	r.OnContinue(func() error {
+		sent++
+		return nil
+	})
+	require.NoError(t, r.Body.Close())
+	assert.Equal(t, 1, sent)
+
+	// Test: Nothing to continue without a body
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: HTTP/1.0 clients can't expect 100 Continue
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+
+	// Test: Unknown expectations are refused with 417
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\nExpect: 200-ok\r\n\r\nhello"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrUnsupportedExpectation)
+	assert.Equal(t, 417, perr.StatusCode)
+}
+
+func TestReaderPipelining(t *testing.T) {
+	// Test: Pipelined requests are read in order from one connection
+	reader := &chunkReader{
+		data: "POST /first HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello" +
+			"POST /second HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nworld\r\n0\r\n\r\n" +
+			"GET /third HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	rr := NewReader(reader)
+	r, err := rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.Path())
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.Path())
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(body))
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.Path())
+
+	_, err = rr.ReadRequest()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Unread bodies are skipped before the next request
+	rr = NewReader(strings.NewReader(
+		"POST /first HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 11\r\n\r\nhello world" +
+			"POST /second HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n" +
+			"\r\nGET /third HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	p := make([]byte, 3)
+	_, err = io.ReadFull(r.Body, p)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.Path())
+
+	r, err = rr.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.Path())
+
+	// Test: Connection closed in the middle of a request
+	rr = NewReader(strings.NewReader("GET /first HTTP/1.1\r\nHost: localhost:42069\r\n\r\nGET /sec"))
+	_, err = rr.ReadRequest()
+	require.NoError(t, err)
+	_, err = rr.ReadRequest()
+	assert.ErrorIs(t, err, ErrIncompleteRequest)
+
+	// Test: Wait returns once the next request starts and after the last one
+	// reports the closed connection
+	rr = NewReader(strings.NewReader("POST /first HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhelloG"))
+	_, err = rr.ReadRequest()
+	require.NoError(t, err)
+	require.NoError(t, rr.Wait())
+	_, err = rr.ReadRequest()
+	assert.ErrorIs(t, err, ErrIncompleteRequest)
+	rr = NewReader(strings.NewReader(""))
+	assert.ErrorIs(t, rr.Wait(), io.EOF)
+}
+
+func TestRequestLargeHead(t *testing.T) {
+	// Test: A header section larger than the initial buffer
+	data := "GET / HTTP/1.1\r\nHost: localhost:42069\r\nX-Large: " + strings.Repeat("a", 3*bufferSize) + "\r\n\r\n"
+	r, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 1000})
+	require.NoError(t, err)
+	assert.Len(t, headerValue(r.Headers, "x-large"), 3*bufferSize)
+	assert.Equal(t, "localhost:42069", headerValue(r.Headers, "host"))
+}
+
+const benchmarkGet = "GET /coffee?size=large HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: curl/7.81.0\r\n" +
+	"Accept: */*\r\n" +
+	"Connection: keep-alive\r\n" +
+	"\r\n"
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	reader := strings.NewReader(benchmarkGet)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkGet)))
+	for b.Loop() {
+		reader.Reset(benchmarkGet)
+		r, err := RequestFromReader(reader)
+		if err != nil {
+			b.Fatal(err)
+		}
+		r.Body.Close()
+	}
+}
+
+func BenchmarkReaderPipelined(b *testing.B) {
+	data := strings.Repeat(benchmarkGet, 64)
+	reader := strings.NewReader(data)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkGet)))
+	var rr *Reader
+	for i := 0; b.Loop(); i++ {
+		if i%64 == 0 {
+			reader.Reset(data)
+			rr = NewReader(reader)
+		}
+		if _, err := rr.ReadRequest(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRequestCookies(t *testing.T) {
+	// Test: Cookies are parsed in order
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Cookie: session=abc123; theme=\"dark\"; bad cookie=1; empty=\r\n" +
+		"Cookie: lang=go\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Cookie{
+		{Name: "session", Value: "abc123"},
+		{Name: "theme", Value: "dark"},
+		{Name: "empty", Value: ""},
+		{Name: "lang", Value: "go"},
+	}, r.Cookies())
+	v, ok := r.Cookie("theme")
+	assert.True(t, ok)
+	assert.Equal(t, "dark", v)
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+
+	// Test: No Cookie header
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
 }
@@ -0,0 +1,208 @@
+package request
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidTarget = errors.New("invalid request target")
+
+type TargetForm int
+
+const (
+	// OriginForm is an absolute path with an optional query, e.g. /where?q=now
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a full URI, used when talking to proxies
+	AbsoluteForm
+	// AuthorityForm is host:port, only used by CONNECT
+	AuthorityForm
+	// AsteriskForm is the lone "*" of a server wide OPTIONS request
+	AsteriskForm
+)
+
+// Target is the request-target of the request line split into its parts
+type Target struct {
+	Form TargetForm
+	// Scheme is only set for the absolute-form
+	Scheme string
+	// Authority is set for the absolute-form and the authority-form
+	Authority string
+	// Path is the percent-decoded path, RawPath is the path as it was sent
+	Path     string
+	RawPath  string
+	RawQuery string
+}
+
+// QueryValues returns all the values of the query parameter key in the order
+// they were sent. The query is scanned on every call, Request.QueryValues
+// keeps the decoded query around for handlers that ask repeatedly.
+func (t Target) QueryValues(key string) []string {
+	var values []string
+	for pair := range strings.SplitSeq(t.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		// the escapes were validated with the request line
+		if k, _ := unescape(rawKey, true); k != key {
+			continue
+		}
+		value, _ := unescape(rawValue, true)
+		values = append(values, value)
+	}
+	return values
+}
+
+func parseTarget(method, target string) (Target, error) {
+	for i := 0; i < len(target); i++ {
+		if target[i] <= ' ' || target[i] == 0x7f || target[i] == '#' {
+			return Target{}, ErrInvalidTarget
+		}
+	}
+
+	t := Target{}
+	switch {
+	case target == "*":
+		if method != "OPTIONS" {
+			return Target{}, ErrInvalidTarget
+		}
+		t.Form = AsteriskForm
+		return t, nil
+	case method == "CONNECT":
+		host, port, ok := strings.Cut(target, ":")
+		if !ok || host == "" || port == "" || strings.ContainsAny(target, "/?@") {
+			return Target{}, ErrInvalidTarget
+		}
+		t.Form = AuthorityForm
+		t.Authority = target
+		return t, nil
+	case strings.HasPrefix(target, "/"):
+		t.Form = OriginForm
+	default:
+		scheme, rest, ok := strings.Cut(target, "://")
+		if !ok || !validScheme(scheme) {
+			return Target{}, ErrInvalidTarget
+		}
+		end := strings.IndexAny(rest, "/?")
+		if end == -1 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return Target{}, ErrInvalidTarget
+		}
+		t.Form = AbsoluteForm
+		t.Scheme = strings.ToLower(scheme)
+		t.Authority = rest[:end]
+		target = rest[end:]
+		if !strings.HasPrefix(target, "/") {
+			// an empty path in the absolute-form means the root
+			target = "/" + target
+		}
+	}
+
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	path, err := unescape(rawPath, false)
+	if err != nil {
+		return Target{}, err
+	}
+	// the query is only split up when a handler asks for it, but a bad
+	// escape still has to fail the request here
+	if !validEscapes(rawQuery) {
+		return Target{}, ErrInvalidTarget
+	}
+	t.Path = path
+	t.RawPath = rawPath
+	t.RawQuery = rawQuery
+	return t, nil
+}
+
+func validScheme(scheme string) bool {
+	if scheme == "" || !isAlpha(scheme[0]) {
+		return false
+	}
+	for i := 1; i < len(scheme); i++ {
+		c := scheme[i]
+		if !isAlpha(c) && !isDigit(c) && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseQuery splits a raw query into its decoded key value pairs, a key with
+// no "=" gets an empty value
+func parseQuery(rawQuery string) (map[string][]string, error) {
+	if rawQuery == "" {
+		return nil, nil
+	}
+	query := map[string][]string{}
+	for pair := range strings.SplitSeq(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := unescape(rawKey, true)
+		if err != nil {
+			return nil, err
+		}
+		value, err := unescape(rawValue, true)
+		if err != nil {
+			return nil, err
+		}
+		query[key] = append(query[key], value)
+	}
+	return query, nil
+}
+
+func validEscapes(s string) bool {
+	for i := strings.IndexByte(s, '%'); i != -1; i = strings.IndexByte(s, '%') {
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return false
+		}
+		s = s[i+3:]
+	}
+	return true
+}
+
+// unescape decodes the %XX escapes in s, in queries a '+' also stands for a space
+func unescape(s string, plusAsSpace bool) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", ErrInvalidTarget
+			}
+			sb.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case c == '+' && plusAsSpace:
+			sb.WriteByte(' ')
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case isDigit(c):
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,148 @@
+package response
+
+import (
+	"fmt"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+)
+
+// SetBufferSize turns on buffered mode, size 0 turns it off. The writer then
+// holds back the headers and collects the body itself: a body that fits in
+// size bytes goes out with a Content-Length, a larger one or one the handler
+// flushes is sent chunked. The writer owns the framing in this mode, any
+// Content-Length the handler set is replaced. Headers asking for chunked
+// coding are still written straight away.
+func (w *Writer) SetBufferSize(size int) {
+	w.bufferSize = size
+}
+
+// holdHeaders keeps h back until the body is known. The fields are checked
+// now so the handler still gets the error from WriteHeaders.
+func (w *Writer) holdHeaders(h *headers.Headers) error {
+	for key, value := range h.All() {
+		if err := headers.ValidateField(key, value); err != nil {
+			return err
+		}
+	}
+	w.pending = h.Clone()
+	w.state = writerStateBody
+	return nil
+}
+
+func (w *Writer) bufferBody(p []byte) (int, error) {
+	if w.head {
+		// only the length of a HEAD response's body matters, however large
+		w.headLength += int64(len(p))
+		return len(p), nil
+	}
+	if len(w.buf)+len(p) <= w.bufferSize {
+		w.buf = append(w.buf, p...)
+		return len(p), nil
+	}
+	// the body won't fit, what is buffered goes out first and p follows as
+	// its own chunk rather than being copied into the buffer
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return w.WriteChunkedBody(p)
+}
+
+// Flush sends everything buffered so far. Held back headers are committed to
+// chunked coding, to a body delimited by closing the connection for HTTP/1.0.
+// A status that can't have a body gets its headers without any framing. It
+// does nothing outside of buffered mode.
+func (w *Writer) Flush() error {
+	if w.pending == nil {
+		return nil
+	}
+	if w.bodiless() {
+		// Transfer-Encoding is forbidden here, the body is dropped anyway
+		return w.commitLength(0)
+	}
+	h := w.pending
+	w.pending = nil
+	h.Remove("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	if err := w.writeHeaderSection(h); err != nil {
+		return err
+	}
+	buf := w.buf
+	w.buf = nil
+	_, err := w.WriteChunkedBody(buf)
+	return err
+}
+
+// Finish completes the response, whatever the handler left out is written
+// for it. A response nothing was written for becomes a 200 with an empty
+// body, a buffered body goes out with its Content-Length and a chunked one
+// gets its last chunk. A body shorter than the Content-Length the handler
+// set can't be completed, the connection has to be closed instead.
+func (w *Writer) Finish() error {
+	if w.interim {
+		if err := w.writeInterimHeaders(headers.NewHeaders()); err != nil {
+			return err
+		}
+	}
+	if w.state == writerStateStatusLine {
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+	}
+	if w.state == writerStateHeaders {
+		if err := w.WriteHeaders(GetDefaultHeaders(0)); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case w.pending != nil:
+		if err := w.commitLength(w.buffered()); err != nil {
+			return err
+		}
+		w.state = writerStateDone
+	case w.state == writerStateBody:
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+		if w.state == writerStateTrailers {
+			return w.WriteTrailers(headers.NewHeaders())
+		}
+	case w.state == writerStateTrailers:
+		return w.WriteTrailers(headers.NewHeaders())
+	}
+	if w.remaining > 0 {
+		// the client is still waiting for the rest of the body
+		w.keepAlive = false
+		return fmt.Errorf("%w: %d bytes short", ErrBodyLength, w.remaining)
+	}
+	return nil
+}
+
+// commitLength writes the held back headers with a Content-Length of
+// length, followed by what was buffered of the body so far
+func (w *Writer) commitLength(length int64) error {
+	h := w.pending
+	w.pending = nil
+	if w.bodiless() {
+		// these never have a body, a Content-Length would be misread
+		h.Remove("Content-Length")
+		w.buf = w.buf[:0]
+	} else {
+		h.Set("Content-Length", fmt.Sprint(length))
+	}
+	if err := w.writeHeaderSection(h); err != nil {
+		return err
+	}
+	buf := w.buf
+	w.buf = nil
+	if w.omitsBody() {
+		return nil
+	}
+	_, err := w.writeRaw(buf)
+	return err
+}
+
+// buffered returns the length of the body held back so far
+func (w *Writer) buffered() int64 {
+	return int64(len(w.buf)) + w.headLength
+}
@@ -0,0 +1,182 @@
+package response
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterBuffered(t *testing.T) {
+	// Test: A small body gets its Content-Length filled in
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetBufferSize(16)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	_, err := w.WriteBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("world"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String(), "headers are held back")
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 11\r\n"+
+		"Content-Type: text/plain\r\n"+
+		testDate+
+		"\r\n"+
+		"hello world", buf.String())
+
+	// Test: Going over the buffer size switches to chunked, the write that
+	// didn't fit is its own chunk
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetBufferSize(4)
+	_, err = w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("def"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("gh"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		testDate+
+		"\r\n"+
+		"3\r\nabc\r\n"+
+		"3\r\ndef\r\n"+
+		"2\r\ngh\r\n"+
+		"0\r\n\r\n", buf.String())
+
+	// Test: A write larger than the buffer is passed on without a copy
+	var writes writeRecorder
+	w = NewWriter(&writes)
+	w.SetBufferSize(4)
+	large := []byte("0123456789")
+	_, err = w.WriteBody(large)
+	require.NoError(t, err)
+	// status line, header section, chunk size, the chunk, CRLF
+	require.Len(t, writes, 5)
+	assert.Same(t, &large[0], &writes[3][0])
+
+	// Test: So does a flush
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	w.SetBufferSize(1024)
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err = w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "Transfer-Encoding: chunked\r\n"+testDate+"\r\n3\r\nabc\r\n", buf.String())
+
+	// Test: HTTP/1.0 falls back to closing the connection
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetVersion("1.0")
+	w.SetBufferSize(2)
+	_, err = w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.0 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Connection: close\r\n"+
+		testDate+
+		"\r\n"+
+		"abc", buf.String())
+
+	// Test: Headers asking for chunked coding aren't held back
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	w.SetBufferSize(1024)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, "Transfer-Encoding: chunked\r\n"+testDate+"\r\n3\r\nabc\r\n", buf.String())
+
+	// Test: Invalid fields are still reported by WriteHeaders
+	w = writerAtHeaders(t, &bytes.Buffer{})
+	w.SetBufferSize(1024)
+	h = headers.NewHeaders()
+	h.Set("X-Bad", "a\r\nb")
+	assert.ErrorIs(t, w.WriteHeaders(h), headers.ErrInvalidFieldValue)
+
+	// Test: HEAD keeps the Content-Length of the body it doesn't send, even
+	// one larger than the buffer
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetHeadResponse(true)
+	w.SetBufferSize(4)
+	_, err = w.WriteBody([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Content-Length: 11\r\n"+
+		testDate+
+		"\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: No Content never gets a body or a length
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetBufferSize(1024)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n"+testDate+"\r\n", buf.String())
+
+	// Test: Not even when the handler writes more than the buffer holds
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetBufferSize(4)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err = w.WriteBody([]byte("hello world"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("again"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n"+testDate+"\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriterFinish(t *testing.T) {
+	// Test: Nothing written becomes an empty 200
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n"))
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+
+	// Test: A chunked body gets its last chunk and empty trailers
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	buf.Reset()
+	_, err := w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "3\r\nabc\r\n0\r\n\r\n", buf.String())
+
+	// Test: A finished response is left alone
+	buf.Reset()
+	require.NoError(t, w.Finish())
+	assert.Empty(t, buf.String())
+}
+
+// writeRecorder keeps every slice written to it without copying
+type writeRecorder [][]byte
+
+func (r *writeRecorder) Write(p []byte) (int, error) {
+	*r = append(*r, p)
+	return len(p), nil
+}
@@ -0,0 +1,117 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+)
+
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out and lets the browser decide
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+// Cookie is sent to the client as a Set-Cookie field
+type Cookie struct {
+	Name   string
+	Value  string
+	Path   string
+	Domain string
+	// Expires is left out when zero
+	Expires time.Time
+	// MaxAge is left out when zero, a negative MaxAge deletes the cookie
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Validate checks that the cookie can be serialized without breaking the
+// Set-Cookie field it is sent in
+func (c *Cookie) Validate() error {
+	if c.Name == "" || !headers.IsToken(c.Name) {
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidCookie, c.Name)
+	}
+	for i := 0; i < len(c.Value); i++ {
+		if b := c.Value[i]; b < 0x21 || b > 0x7e || b == '"' || b == ',' || b == ';' || b == '\\' {
+			return fmt.Errorf("%w: invalid value for %q", ErrInvalidCookie, c.Name)
+		}
+	}
+	if !validAttributeValue(c.Path) {
+		return fmt.Errorf("%w: invalid path for %q", ErrInvalidCookie, c.Name)
+	}
+	if !validAttributeValue(c.Domain) || strings.ContainsAny(c.Domain, " /") {
+		return fmt.Errorf("%w: invalid domain for %q", ErrInvalidCookie, c.Name)
+	}
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		// browsers drop these cookies unless they are also Secure
+		return fmt.Errorf("%w: %q must be Secure", ErrInvalidCookie, c.Name)
+	}
+	return nil
+}
+
+// String renders the cookie as a Set-Cookie field value, it doesn't validate
+// the cookie
+func (c *Cookie) String() string {
+	var sb strings.Builder
+	sb.WriteString(c.Name)
+	sb.WriteByte('=')
+	sb.WriteString(c.Value)
+	if c.Path != "" {
+		sb.WriteString("; Path=")
+		sb.WriteString(c.Path)
+	}
+	if c.Domain != "" {
+		sb.WriteString("; Domain=")
+		sb.WriteString(strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		sb.WriteString("; Expires=")
+		sb.WriteString(headers.FormatTime(c.Expires))
+	}
+	if c.MaxAge > 0 {
+		sb.WriteString("; Max-Age=")
+		sb.WriteString(strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		sb.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		sb.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		sb.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		sb.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		sb.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		sb.WriteString("; SameSite=None")
+	}
+	if c.Partitioned {
+		sb.WriteString("; Partitioned")
+	}
+	return sb.String()
+}
+
+func validAttributeValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if b := v[i]; b < 0x20 || b == 0x7f || b == ';' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieString(t *testing.T) {
+	// Test: Every attribute
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Validate())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Wed, 21 Oct 2015 07:28:00 GMT; "+
+		"Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	// Test: Deleting a cookie
+	c = &Cookie{Name: "session", MaxAge: -1, SameSite: SameSiteLax}
+	require.NoError(t, c.Validate())
+	assert.Equal(t, "session=; Max-Age=0; SameSite=Lax", c.String())
+
+	// Test: Invalid cookies
+	assert.ErrorIs(t, (&Cookie{Name: "bad name", Value: "x"}).Validate(), ErrInvalidCookie)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Value: "x;y"}).Validate(), ErrInvalidCookie)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Value: "x", Path: "/\r\nX-Injected: 1"}).Validate(), ErrInvalidCookie)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Value: "x", SameSite: SameSiteNone}).Validate(), ErrInvalidCookie)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Value: "x", Partitioned: true}).Validate(), ErrInvalidCookie)
+}
+
+func TestWriterSetCookie(t *testing.T) {
+	// Test: Each cookie goes out on its own Set-Cookie line
+	buf := &bytes.Buffer{}
+	w := writerAtHeaders(t, buf)
+	require.NoError(t, w.SetCookie(&Cookie{Name: "a", Value: "1", Expires: time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)}))
+	require.NoError(t, w.SetCookie(&Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	require.Error(t, w.SetCookie(&Cookie{Name: "c", Value: "a b"}))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.Equal(t, "Connection: close\r\n"+
+		testDate+
+		"Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\n"+
+		"Set-Cookie: b=2; HttpOnly\r\n"+
+		"\r\n", buf.String())
+}
@@ -0,0 +1,31 @@
+package response
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+)
+
+// timeNow is swapped out by tests that need a fixed Date
+var timeNow = time.Now
+
+type cachedDate struct {
+	unix  int64
+	value string
+}
+
+// dateCache holds the Date value of the current second, every response in
+// the same second shares it instead of formatting the time again
+var dateCache atomic.Pointer[cachedDate]
+
+// currentDate returns the Date field value for now
+func currentDate() string {
+	now := timeNow()
+	if cached := dateCache.Load(); cached != nil && cached.unix == now.Unix() {
+		return cached.value
+	}
+	cached := &cachedDate{unix: now.Unix(), value: headers.FormatTime(now)}
+	dateCache.Store(cached)
+	return cached.value
+}
@@ -1,86 +1,493 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"slices"
+	"strings"
 
 	"github.com/seandisero/httpfromtcp/internal/headers"
 )
 
+type WriterState int
+
+const (
+	writerStateStatusLine WriterState = iota
+	writerStateHeaders
+	writerStateBody
+	writerStateTrailers
+	writerStateDone
+)
+
+func (s WriterState) String() string {
+	switch s {
+	case writerStateStatusLine:
+		return "status line"
+	case writerStateHeaders:
+		return "headers"
+	case writerStateBody:
+		return "body"
+	case writerStateTrailers:
+		return "trailers"
+	default:
+		return "end of the response"
+	}
+}
+
+var (
+	ErrWriteOrder        = errors.New("response written out of order")
+	ErrUndeclaredTrailer = errors.New("trailer not announced in the Trailer header")
+	ErrBodyLength        = errors.New("body length doesn't match Content-Length")
+)
+
 type Writer struct {
-	writer io.Writer
+	writer       io.Writer
+	version      string
+	chunked      bool
+	state        WriterState
+	preserveCase bool
+	cookies      []string
+	// trailers holds the lowercased names the Trailer header announced
+	trailers []string
+	// interim is set while the header section of a 1xx response is due
+	interim bool
+	// status is the code of the final status line
+	status StatusCode
+	// keepAlive starts as whether the connection may be reused and ends as
+	// whether it will be, once the headers are written
+	keepAlive bool
+	// head is set when answering a HEAD request, see SetHeadResponse
+	head bool
+	// continuePending is set while the client waits for 100 Continue
+	continuePending bool
+	// remaining is how much of the announced Content-Length is still due,
+	// -1 when the body isn't delimited by one
+	remaining int64
+
+	// bufferSize turns on buffered mode, see SetBufferSize. pending holds
+	// the headers until the writer knows how to frame the body in buf.
+	bufferSize int
+	pending    *headers.Headers
+	buf        []byte
+	// headLength counts the body of a buffered HEAD response in place of buf
+	headLength int64
 }
 
 func NewWriter(writer io.Writer) *Writer {
 	return &Writer{
-		writer: writer,
+		writer:    writer,
+		version:   "1.1",
+		keepAlive: true,
+		remaining: -1,
 	}
 }
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
+// SetVersion sets the HTTP version used in the status line, it should match
+// the version of the request being answered. An HTTP/1.0 response never uses
+// chunked transfer coding.
+func (w *Writer) SetVersion(version string) {
+	w.version = version
+}
+
+// SetKeepAlive tells the writer whether the connection may carry another
+// request after this response, a response that won't be followed by one says
+// so with "Connection: close"
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// KeepAlive reports whether the connection can be reused after the response.
+// Besides SetKeepAlive the handler can turn it off with "Connection: close",
+// and so does a body that only ends when the connection is closed.
+func (w *Writer) KeepAlive() bool {
+	return w.keepAlive
+}
+
+// SetHeadResponse marks the response as the answer to a HEAD request. The
+// headers are written as they would be for GET, Content-Length included, but
+// the body is dropped since the client won't read one.
+func (w *Writer) SetHeadResponse(head bool) {
+	w.head = head
+}
+
+// SetContinuePending tells the writer the client holds the request body back
+// until it gets a 100 Continue. A final response that goes out before
+// WriteContinue was called says "Connection: close", the body will never
+// arrive and the connection can't carry another request.
+func (w *Writer) SetContinuePending(pending bool) {
+	w.continuePending = pending
+}
+
+// SetPreserveHeaderCase turns off the canonical casing of field names, the
+// headers are then written with the names exactly as the caller set them
+func (w *Writer) SetPreserveHeaderCase(preserve bool) {
+	w.preserveCase = preserve
+}
+
+// SetCookie queues c to be sent with the headers, every cookie gets its own
+// Set-Cookie line since they can't be combined into one field
+func (w *Writer) SetCookie(c *Cookie) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	w.cookies = append(w.cookies, c.String())
+	return nil
+}
+
+func GetDefaultHeaders(contentLen int) *headers.Headers {
 	hdrs := headers.NewHeaders()
 	hdrs.Set("Content-Length", fmt.Sprintf("%d", contentLen))
-	hdrs.Set("Connection", "close")
 	hdrs.Set("Content-Type", "text/plain")
 
 	return hdrs
 }
 
-func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	var statusLine = []byte{}
-	switch statusCode {
-	case StatusOK:
-		statusLine = []byte("HTTP/1.1 200 OK\r\n")
-	case StatusBadRequest:
-		statusLine = []byte("HTTP/1.1 400 Bad Request\r\n")
-	case StatusInternalServerError:
-		statusLine = []byte("HTTP/1.1 500 Internal Server Error\r\n")
-	default:
-		return fmt.Errorf("undefined status code behaviour")
-	}
+// StatusWritten reports whether the final status line has gone out, the
+// response can't be changed into another one after that
+func (w *Writer) StatusWritten() bool {
+	return w.state > writerStateStatusLine && !w.interim
+}
 
-	_, err := w.writer.Write(statusLine)
+// WriteContinue sends the 100 Continue interim response. It does nothing once
+// the final status line has gone out, the client has its answer by then.
+func (w *Writer) WriteContinue() error {
+	if w.state != writerStateStatusLine || w.version == "1.0" {
+		return nil
+	}
+	_, err := w.writer.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
 	if err != nil {
 		return err
 	}
+	w.continuePending = false
 	return nil
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	data := []byte{}
-	for key, value := range headers {
-		data = fmt.Append(data, key, ": ", value, "\r\n")
+// WriteHeaders writes the header section, it has to come right after the
+// status line
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
+	if w.state != writerStateHeaders {
+		return w.orderError("WriteHeaders")
+	}
+	if w.interim {
+		return w.writeInterimHeaders(h)
+	}
+	if _, ok := h.Get("Transfer-Encoding"); !ok && w.bufferSize > 0 {
+		return w.holdHeaders(h)
+	}
+	return w.writeHeaderSection(h)
+}
+
+// writeHeaderSection writes the final header section and decides the framing
+// the body is written with
+func (w *Writer) writeHeaderSection(h *headers.Headers) error {
+	if _, ok := h.Get("Transfer-Encoding"); ok {
+		codings, err := h.TransferEncoding()
+		if err != nil {
+			return err
+		}
+		last := len(codings) - 1
+		if slices.Contains(codings[:max(last, 0)], "chunked") {
+			// chunked can only be applied once, as the final coding
+			return fmt.Errorf("%w: chunked before %s", headers.ErrInvalidTransferCoding, codings[last])
+		}
+		if w.version != "1.0" && (last == -1 || codings[last] != "chunked") {
+			// only chunked delimits the body, the other codings are framed
+			// with it or the client would read until the connection closes
+			h = h.Clone()
+			h.Set("Transfer-Encoding", strings.Join(append(codings, "chunked"), ", "))
+		}
+		if w.version == "1.0" {
+			// HTTP/1.0 clients don't know chunked, the body is delimited by
+			// closing the connection instead
+			h = h.Clone()
+			h.Remove("Transfer-Encoding")
+			h.Remove("Trailer")
+		} else {
+			w.chunked = true
+			w.trailers = h.Trailer()
+		}
+	}
+	h = w.connectionHeader(h)
+
+	data, err := w.appendFields(nil, h)
+	if err != nil {
+		return err
+	}
+	w.remaining, err = h.ContentLength()
+	if err != nil {
+		return err
+	}
+	if w.chunked || w.omitsBody() {
+		w.remaining = -1
+	}
+	if _, ok := h.Get("Date"); !ok {
+		// RFC 9110 wants a Date from every origin server with a clock
+		data = w.appendField(data, "Date", currentDate())
+	}
+	for _, cookie := range w.cookies {
+		data = w.appendField(data, "Set-Cookie", cookie)
 	}
 	data = fmt.Append(data, "\r\n")
-	_, err := w.writer.Write(data)
+	_, err = w.writer.Write(data)
+	if err != nil {
+		return err
+	}
+	w.state = writerStateBody
+	return nil
+}
+
+// connectionHeader settles whether the connection outlives the response and
+// makes the Connection field of h say so, h is cloned before it is changed
+func (w *Writer) connectionHeader(h *headers.Headers) *headers.Headers {
+	options := h.Connection()
+	_, hasLength := h.Get("Content-Length")
+	w.keepAlive = w.keepAlive && !w.continuePending && !slices.Contains(options, "close") &&
+		(w.chunked || hasLength || w.omitsBody())
+
+	switch {
+	case !w.keepAlive && !slices.Contains(options, "close"):
+		h = h.Clone()
+		h.Set("Connection", "close")
+	case w.keepAlive && w.version == "1.0" && !slices.Contains(options, "keep-alive"):
+		// persistence is opt in for HTTP/1.0
+		h = h.Clone()
+		h.Set("Connection", "keep-alive")
+	}
+	return h
+}
+
+// bodiless reports whether the response can't have a body whatever its
+// headers say
+func (w *Writer) bodiless() bool {
+	return w.status == StatusNoContent || w.status == StatusNotModified
+}
+
+// omitsBody reports whether body writes are dropped instead of sent, for a
+// HEAD response or a status that can't have a body
+func (w *Writer) omitsBody() bool {
+	return w.head || w.bodiless()
+}
+
+// writeInterimHeaders ends a 1xx response, framing and cookies belong to the
+// final response so h is written as is
+func (w *Writer) writeInterimHeaders(h *headers.Headers) error {
+	data, err := w.appendFields(nil, h)
 	if err != nil {
 		return err
 	}
+	data = fmt.Append(data, "\r\n")
+	if _, err := w.writer.Write(data); err != nil {
+		return err
+	}
+	w.state = writerStateStatusLine
+	w.interim = false
 	return nil
 }
 
+// WriteBody writes p as part of the body, as a chunk if the headers asked for
+// chunked transfer coding. A handler that starts with the body gets a 200 and
+// default headers sent for it, without a Content-Length the body then runs
+// until the connection is closed.
 func (w *Writer) WriteBody(p []byte) (int, error) {
-	n, err := w.writer.Write(p)
-	return n, err
+	if err := w.startBody(); err != nil {
+		return 0, err
+	}
+	if w.pending != nil {
+		return w.bufferBody(p)
+	}
+	if w.chunked {
+		return w.WriteChunkedBody(p)
+	}
+	if w.state != writerStateBody {
+		return 0, w.orderError("WriteBody")
+	}
+	if w.omitsBody() {
+		return len(p), nil
+	}
+	return w.writeRaw(p)
 }
 
+// WriteChunkedBody writes p as one chunk. An empty p writes nothing, a zero
+// sized chunk would end the body. When chunked coding was dropped for an
+// HTTP/1.0 client p is written as is.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	return w.WriteBody(p)
+	if err := w.startBody(); err != nil {
+		return 0, err
+	}
+	if w.state != writerStateBody {
+		return 0, w.orderError("WriteChunkedBody")
+	}
+	if w.pending != nil {
+		return w.bufferBody(p)
+	}
+	if w.omitsBody() {
+		return len(p), nil
+	}
+	if !w.chunked {
+		return w.writeRaw(p)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// p isn't copied, a net.Conn writes the three parts with one writev
+	chunk := net.Buffers{fmt.Appendf(nil, "%x\r\n", len(p)), p, []byte("\r\n")}
+	if _, err := chunk.WriteTo(w.writer); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
+
+// WriteChunkedBodyDone writes the last chunk. If the headers announced
+// trailers the response then waits for WriteTrailers, otherwise it is
+// complete.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	return w.WriteBody([]byte("\r\n"))
+	if w.state != writerStateBody {
+		return 0, w.orderError("WriteChunkedBodyDone")
+	}
+	if w.pending != nil {
+		return 0, w.Finish()
+	}
+	if !w.chunked {
+		w.state = writerStateDone
+		return 0, nil
+	}
+	if w.omitsBody() {
+		// the trailers are still expected from the handler, not sent either
+		w.state = writerStateTrailers
+		if len(w.trailers) == 0 {
+			w.state = writerStateDone
+		}
+		return 0, nil
+	}
+	if len(w.trailers) > 0 {
+		n, err := w.writer.Write([]byte("0\r\n"))
+		if err != nil {
+			return n, err
+		}
+		w.state = writerStateTrailers
+		return n, nil
+	}
+	n, err := w.writer.Write([]byte("0\r\n\r\n"))
+	if err != nil {
+		return n, err
+	}
+	w.state = writerStateDone
+	return n, nil
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
-	data := []byte{}
-	for key, value := range h {
-		data = fmt.Append(data, key, ": ", value, "\r\n")
+// WriteTrailers ends a chunked body with the trailer fields in h, writing the
+// last chunk first if WriteChunkedBodyDone wasn't called. Every field has to
+// be announced in the Trailer header.
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
+	if w.state != writerStateBody && w.state != writerStateTrailers {
+		return w.orderError("WriteTrailers")
+	}
+	if w.pending != nil {
+		// the headers didn't ask for chunked coding, so there is no Trailer
+		// either and nothing can be announced
+		if err := w.Finish(); err != nil {
+			return err
+		}
+	}
+	if !w.chunked {
+		// trailers can only be sent with a chunked body
+		w.state = writerStateDone
+		return nil
+	}
+	for name := range h.All() {
+		if !slices.Contains(w.trailers, strings.ToLower(name)) {
+			return fmt.Errorf("%w: %q", ErrUndeclaredTrailer, name)
+		}
+	}
+	if w.omitsBody() {
+		// trailers belong to the body
+		w.state = writerStateDone
+		return nil
+	}
+
+	var data []byte
+	if w.state == writerStateBody {
+		data = fmt.Append(data, "0\r\n")
+	}
+	data, err := w.appendFields(data, h)
+	if err != nil {
+		return err
 	}
 	data = fmt.Append(data, "\r\n")
-	_, err := w.writer.Write(data)
+	_, err = w.writer.Write(data)
 	if err != nil {
 		return err
 	}
+	w.state = writerStateDone
+	return nil
+}
+
+// writeRaw writes p to the connection as is, a body delimited by its
+// Content-Length can't run past it
+func (w *Writer) writeRaw(p []byte) (int, error) {
+	if err := w.reserve(int64(len(p))); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(p)
+}
+
+// reserve counts n more body bytes against the Content-Length
+func (w *Writer) reserve(n int64) error {
+	if w.remaining == -1 {
+		return nil
+	}
+	if n > w.remaining {
+		return fmt.Errorf("%w: %d bytes over", ErrBodyLength, n-w.remaining)
+	}
+	w.remaining -= n
+	return nil
+}
 
+// startBody sends whatever the handler skipped before its first body write
+func (w *Writer) startBody() error {
+	if w.interim {
+		if err := w.writeInterimHeaders(headers.NewHeaders()); err != nil {
+			return err
+		}
+	}
+	if w.state == writerStateStatusLine {
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+	}
+	if w.state == writerStateHeaders {
+		h := GetDefaultHeaders(0)
+		h.Remove("Content-Length")
+		if err := w.WriteHeaders(h); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func (w *Writer) orderError(call string) error {
+	return fmt.Errorf("%w: %s called, expected %s", ErrWriteOrder, call, w.state)
+}
+
+// appendFields serializes h in the order the fields were added. Nothing is
+// written if any field is invalid, a half written header section can't be
+// taken back.
+func (w *Writer) appendFields(data []byte, h *headers.Headers) ([]byte, error) {
+	for key, value := range h.All() {
+		if err := headers.ValidateField(key, value); err != nil {
+			return nil, err
+		}
+		data = w.appendField(data, key, value)
+	}
+	return data, nil
+}
+
+func (w *Writer) appendField(data []byte, key, value string) []byte {
+	if !w.preserveCase {
+		key = headers.CanonicalName(key)
+	}
+	return fmt.Append(data, key, ": ", value, "\r\n")
+}
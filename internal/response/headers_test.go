@@ -0,0 +1,412 @@
+package response
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDate is what every response in these tests is dated
+const testDate = "Date: Wed, 21 Oct 2015 07:28:00 GMT\r\n"
+
+func TestMain(m *testing.M) {
+	timeNow = func() time.Time { return time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC) }
+	os.Exit(m.Run())
+}
+
+// writerAtHeaders returns a Writer that has sent its status line, buf only
+// collects what comes after it
+func writerAtHeaders(t *testing.T, buf *bytes.Buffer) *Writer {
+	t.Helper()
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	buf.Reset()
+	return w
+}
+
+func TestWriterVersion(t *testing.T) {
+	// Test: Default status line version
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+
+	// Test: HTTP/1.0 status line
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(StatusBadRequest))
+	assert.Equal(t, "HTTP/1.0 400 Bad Request\r\n", buf.String())
+
+	// Test: HTTP/1.0 responses are never chunked
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	w.SetVersion("1.0")
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "x-checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "Connection: close\r\n"+testDate+"\r\n", buf.String())
+	_, ok := h.Get("Transfer-Encoding")
+	assert.True(t, ok, "caller headers are left untouched")
+
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "Connection: close\r\n"+testDate+"\r\n", buf.String())
+}
+
+func TestWriterContinue(t *testing.T) {
+	// Test: 100 Continue before the final response
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteContinue())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n", buf.String())
+
+	// Test: No 100 Continue once the final status was written
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusExpectationFailed))
+	require.NoError(t, w.WriteContinue())
+	assert.Equal(t, "HTTP/1.1 417 Expectation Failed\r\n", buf.String())
+}
+
+func TestWriterHeaderOrder(t *testing.T) {
+	// Test: Fields go out in insertion order with canonical names
+	buf := &bytes.Buffer{}
+	w := writerAtHeaders(t, buf)
+	h := GetDefaultHeaders(5)
+	h.Add("x-request-id", "42")
+	h.Add("cache-control", "no-cache")
+	h.Add("Cache-Control", "no-store")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "Content-Length: 5\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"X-Request-Id: 42\r\n"+
+		"Cache-Control: no-cache\r\n"+
+		"Cache-Control: no-store\r\n"+
+		testDate+
+		"\r\n", buf.String())
+
+	// Test: Caller casing is kept when asked to
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	w.SetPreserveHeaderCase(true)
+	h = headers.NewHeaders()
+	h.Add("x-lowercase", "1")
+	h.Add("X-MixedCASE", "2")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "x-lowercase: 1\r\nX-MixedCASE: 2\r\nConnection: close\r\n"+testDate+"\r\n", buf.String())
+}
+
+func TestWriterHeaderInjection(t *testing.T) {
+	// Test: A CRLF in a value is refused before anything is written
+	buf := &bytes.Buffer{}
+	w := writerAtHeaders(t, buf)
+	h := headers.NewHeaders()
+	h.Add("Location", "/next\r\nSet-Cookie: session=stolen")
+	err := w.WriteHeaders(h)
+	assert.ErrorIs(t, err, headers.ErrInvalidFieldValue)
+	assert.Empty(t, buf.String())
+
+	// Test: So is a name that isn't a token
+	h = headers.NewHeaders()
+	h.Add("X-Bad\r\nName", "1")
+	err = w.WriteHeaders(h)
+	assert.ErrorIs(t, err, headers.ErrInvalidFieldName)
+	assert.Empty(t, buf.String())
+
+	// Test: Trailers are checked the same way
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	h = headers.NewHeaders()
+	h.Add("Transfer-Encoding", "chunked")
+	h.Add("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	buf.Reset()
+	trailers := headers.NewHeaders()
+	trailers.Add("X-Checksum", "abc\x00")
+	err = w.WriteTrailers(trailers)
+	assert.ErrorIs(t, err, headers.ErrInvalidFieldValue)
+	assert.Empty(t, buf.String())
+}
+
+func TestWriterDate(t *testing.T) {
+	// Test: A Date the caller set is kept
+	buf := &bytes.Buffer{}
+	w := writerAtHeaders(t, buf)
+	h := headers.NewHeaders()
+	h.Set("Date", "Thu, 01 Jan 1970 00:00:00 GMT")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "Date: Thu, 01 Jan 1970 00:00:00 GMT\r\nConnection: close\r\n\r\n", buf.String())
+
+	// Test: The formatted date is reused within the same second
+	first := currentDate()
+	assert.Equal(t, "Wed, 21 Oct 2015 07:28:00 GMT", first)
+	cached := dateCache.Load()
+	currentDate()
+	assert.Same(t, cached, dateCache.Load())
+
+	// Test: And refreshed once the second is over
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+	timeNow = func() time.Time { return time.Date(2015, time.October, 21, 7, 28, 1, 0, time.UTC) }
+	assert.Equal(t, "Wed, 21 Oct 2015 07:28:01 GMT", currentDate())
+	assert.NotSame(t, cached, dateCache.Load())
+}
+
+func TestWriterOrder(t *testing.T) {
+	// Test: A body written first gets a 200 and default headers
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Connection: close\r\n"+
+		testDate+
+		"\r\n"+
+		"hello", buf.String())
+
+	// Test: So does a body written right after the status line
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	_, err = w.WriteBody([]byte("gone"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Connection: close\r\n"+
+		testDate+
+		"\r\n"+
+		"gone", buf.String())
+
+	// Test: Out of order calls are refused without writing anything
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	err = w.WriteHeaders(GetDefaultHeaders(0))
+	assert.ErrorIs(t, err, ErrWriteOrder)
+	assert.EqualError(t, err, "response written out of order: WriteHeaders called, expected status line")
+	assert.ErrorIs(t, w.WriteTrailers(headers.NewHeaders()), ErrWriteOrder)
+	assert.Empty(t, buf.String())
+
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrWriteOrder)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	err = w.WriteHeaders(GetDefaultHeaders(0))
+	assert.EqualError(t, err, "response written out of order: WriteHeaders called, expected body")
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	buf.Reset()
+	_, err = w.WriteBody([]byte("late"))
+	assert.EqualError(t, err, "response written out of order: WriteBody called, expected end of the response")
+	assert.Empty(t, buf.String())
+
+	// Test: Interim responses have their own headers before the final status
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusEarlyHints))
+	hints := headers.NewHeaders()
+	hints.Add("Link", "</style.css>; rel=preload")
+	require.NoError(t, w.WriteHeaders(hints))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\n"+
+		"Link: </style.css>; rel=preload\r\n"+
+		"\r\n"+
+		"HTTP/1.1 200 OK\r\n", buf.String())
+}
+
+func TestWriterChunked(t *testing.T) {
+	// Test: Chunks are framed, trailers follow the last chunk
+	buf := &bytes.Buffer{}
+	w := writerAtHeaders(t, buf)
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum, x-length")
+	require.NoError(t, w.WriteHeaders(h))
+	buf.Reset()
+	n, err := w.WriteChunkedBody([]byte("hello world, this is a chunk"))
+	require.NoError(t, err)
+	assert.Equal(t, 28, n)
+	n, err = w.WriteBody([]byte("!"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	trailers.Set("X-Length", "29")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "1c\r\nhello world, this is a chunk\r\n"+
+		"1\r\n!\r\n"+
+		"0\r\n"+
+		"X-Checksum: abc\r\n"+
+		"X-Length: 29\r\n"+
+		"\r\n", buf.String())
+
+	// Test: Without announced trailers the last chunk ends the body
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	h = headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	buf.Reset()
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.Equal(t, "3\r\nabc\r\n0\r\n\r\n", buf.String())
+	assert.ErrorIs(t, w.WriteTrailers(headers.NewHeaders()), ErrWriteOrder)
+
+	// Test: With announced trailers the body waits for them
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	buf.Reset()
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("late"))
+	assert.ErrorIs(t, err, ErrWriteOrder)
+
+	// Test: Trailers that weren't announced are refused
+	trailers = headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	trailers.Set("X-Sneaky", "1")
+	err = w.WriteTrailers(trailers)
+	assert.ErrorIs(t, err, ErrUndeclaredTrailer)
+	assert.Equal(t, "0\r\n", buf.String())
+	trailers.Del("X-Sneaky")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "0\r\nX-Checksum: abc\r\n\r\n", buf.String())
+
+	// Test: HTTP/1.0 gets the chunks unframed
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	buf.Reset()
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.Equal(t, "abc", buf.String())
+
+	// Test: Other codings get chunked added, the body is framed by it
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	gzip := headers.NewHeaders()
+	gzip.Set("Transfer-Encoding", "gzip")
+	require.NoError(t, w.WriteHeaders(gzip))
+	_, err = w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "Transfer-Encoding: gzip, chunked\r\n"+testDate+"\r\n3\r\nabc\r\n0\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Chunked applied before another coding is refused
+	w = writerAtHeaders(t, &bytes.Buffer{})
+	gzip.Set("Transfer-Encoding", "chunked, gzip")
+	assert.ErrorIs(t, w.WriteHeaders(gzip), headers.ErrInvalidTransferCoding)
+
+	// Test: HEAD gets the chunked headers but no chunks or trailers
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	w.SetHeadResponse(true)
+	require.NoError(t, w.WriteHeaders(h))
+	buf.Reset()
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Empty(t, buf.String())
+}
+
+func TestWriterKeepAlive(t *testing.T) {
+	// Test: A delimited body keeps the connection without saying so
+	buf := &bytes.Buffer{}
+	w := writerAtHeaders(t, buf)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.NotContains(t, buf.String(), "Connection")
+	assert.True(t, w.KeepAlive())
+
+	// Test: The server ending the connection announces it
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	w.SetKeepAlive(false)
+	h := GetDefaultHeaders(0)
+	h.Set("Connection", "keep-alive")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Contains(t, buf.String(), "Connection: close\r\n")
+	assert.NotContains(t, buf.String(), "keep-alive")
+	assert.False(t, w.KeepAlive())
+
+	// Test: So does a handler
+	w = writerAtHeaders(t, &bytes.Buffer{})
+	h = GetDefaultHeaders(0)
+	h.Set("Connection", "Close")
+	require.NoError(t, w.WriteHeaders(h))
+	assert.False(t, w.KeepAlive())
+
+	// Test: HTTP/1.0 has to be told the connection stays open
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.Contains(t, buf.String(), "Connection: keep-alive\r\n")
+	assert.True(t, w.KeepAlive())
+
+	// Test: No Content needs no length to be reused
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.True(t, w.KeepAlive())
+
+	// Test: A client still holding back its body for 100 Continue is told
+	// the connection ends
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	w.SetContinuePending(true)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.Contains(t, buf.String(), "Connection: close\r\n")
+	assert.False(t, w.KeepAlive())
+
+	// Test: Not once it was sent
+	w = NewWriter(&bytes.Buffer{})
+	w.SetContinuePending(true)
+	require.NoError(t, w.WriteContinue())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.True(t, w.KeepAlive())
+
+	// Test: A body shorter than its Content-Length ends the connection
+	w = writerAtHeaders(t, &bytes.Buffer{})
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	_, err := w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.Finish(), ErrBodyLength)
+	assert.False(t, w.KeepAlive())
+
+	// Test: One running past it is refused
+	buf = &bytes.Buffer{}
+	w = writerAtHeaders(t, buf)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3)))
+	buf.Reset()
+	_, err = w.WriteBody([]byte("ab"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("cd"))
+	assert.ErrorIs(t, err, ErrBodyLength)
+	assert.Equal(t, "ab", buf.String())
+	_, err = w.WriteBody([]byte("c"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+}
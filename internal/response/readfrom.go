@@ -0,0 +1,115 @@
+package response
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Write makes the Writer an io.Writer, it is WriteBody
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+// ReadFrom writes the rest of r as the body. A regular file is handed to the
+// connection's own ReadFrom, a *net.TCPConn sends it with sendfile or splice
+// without copying it through user space. In buffered mode the size of the
+// file settles the Content-Length, a chunked body gets the file as a single
+// chunk. Only the size the file had when ReadFrom was called is sent, a file
+// that shrank since fails with io.ErrUnexpectedEOF. Any other reader is
+// copied through WriteBody.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if err := w.startBody(); err != nil {
+		return 0, err
+	}
+	if w.state != writerStateBody {
+		return 0, w.orderError("ReadFrom")
+	}
+	size, known := fileRemaining(r)
+	if !known {
+		return io.Copy(bodyWriter{w}, r)
+	}
+
+	if w.pending != nil {
+		if err := w.commitLength(w.buffered() + size); err != nil {
+			return 0, err
+		}
+	}
+	if w.omitsBody() || size == 0 {
+		return 0, nil
+	}
+	if !w.chunked {
+		// the Content-Length may have been worked out from size, a file
+		// growing in the meantime mustn't overrun it
+		return w.sendSize(r, size)
+	}
+	if _, err := fmt.Fprintf(w.writer, "%x\r\n", size); err != nil {
+		return 0, err
+	}
+	n, err := w.sendSize(r, size)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(w.writer, "\r\n")
+	return n, err
+}
+
+// sendSize sends exactly size bytes of r, sendfile still applies to a file
+// behind an io.LimitedReader
+func (w *Writer) sendSize(r io.Reader, size int64) (int64, error) {
+	if !w.chunked {
+		if err := w.reserve(size); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.sendFrom(&io.LimitedReader{R: r, N: size})
+	if err != nil {
+		return n, err
+	}
+	if n != size {
+		// the file shrank under us, the body can't be completed
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+// sendFrom copies r to the connection, through its ReadFrom if it has one
+func (w *Writer) sendFrom(r io.Reader) (int64, error) {
+	if rf, ok := w.writer.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(w.writer, r)
+}
+
+// file is what fileRemaining needs of a reader. It matches *os.File and the
+// wrapper io.Copy hands over after going through (*os.File).WriteTo.
+type file interface {
+	io.Seeker
+	Stat() (os.FileInfo, error)
+}
+
+// fileRemaining returns how much is left to read of r if it is a regular file
+func fileRemaining(r io.Reader) (int64, bool) {
+	f, ok := r.(file)
+	if !ok {
+		return 0, false
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil || offset > info.Size() {
+		return 0, false
+	}
+	return info.Size() - offset, true
+}
+
+// bodyWriter hides ReadFrom so io.Copy doesn't call back into it
+type bodyWriter struct {
+	w *Writer
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	return b.w.WriteBody(p)
+}
@@ -0,0 +1,176 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/seandisero/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readerFromBuffer records what ReadFrom was handed, like a *net.TCPConn
+// would pick sendfile for a file
+type readerFromBuffer struct {
+	bytes.Buffer
+	sources []io.Reader
+}
+
+func (b *readerFromBuffer) ReadFrom(r io.Reader) (int64, error) {
+	b.sources = append(b.sources, r)
+	return b.Buffer.ReadFrom(r)
+}
+
+func tempFile(t *testing.T, content string) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "body")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	// Test: A file settles the Content-Length and goes to the connection
+	conn := &readerFromBuffer{}
+	w := NewWriter(conn)
+	w.SetBufferSize(4)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	_, err := w.Write([]byte("ab"))
+	require.NoError(t, err)
+	f := tempFile(t, "0123456789")
+	n, err := io.Copy(w, f)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 12\r\n"+
+		testDate+
+		"\r\n"+
+		"ab0123456789", conn.String())
+	require.Len(t, conn.sources, 1)
+	// net unwraps an io.LimitedReader before it tries sendfile
+	limited, ok := conn.sources[0].(*io.LimitedReader)
+	require.True(t, ok, "the file is limited to the announced length")
+	assert.Implements(t, (*syscall.Conn)(nil), limited.R, "sendfile needs the file descriptor")
+
+	// Test: A chunked body gets the rest of the file as one chunk
+	conn = &readerFromBuffer{}
+	w = NewWriter(conn)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	conn.Reset()
+	f = tempFile(t, "0123456789abcdef!")
+	_, err = f.Seek(3, io.SeekStart)
+	require.NoError(t, err)
+	_, err = w.ReadFrom(f)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "e\r\n3456789abcdef!\r\n0\r\n\r\n", conn.String())
+
+	// Test: A file growing after its size was taken doesn't overrun the
+	// Content-Length
+	conn = &readerFromBuffer{}
+	w = NewWriter(conn)
+	w.SetBufferSize(4)
+	f = tempFile(t, "0123")
+	size, _ := fileRemaining(f)
+	require.NoError(t, os.WriteFile(f.Name(), []byte("01234567"), 0o600))
+	n, err = w.sendSize(f, size)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+	assert.Equal(t, "0123", conn.String())
+
+	// Test: One that shrank fails the response
+	conn = &readerFromBuffer{}
+	w = NewWriter(conn)
+	f = tempFile(t, "0123")
+	_, err = w.sendSize(f, 8)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: No Content ignores the file
+	conn = &readerFromBuffer{}
+	w = NewWriter(conn)
+	w.SetBufferSize(1024)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	n, err = w.ReadFrom(tempFile(t, "0123456789"))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n"+testDate+"\r\n", conn.String())
+
+	// Test: Other readers are copied through WriteBody
+	conn = &readerFromBuffer{}
+	w = NewWriter(conn)
+	w.SetBufferSize(1024)
+	require.NoError(t, json.NewEncoder(w).Encode(map[string]int{"answer": 42}))
+	_, err = w.ReadFrom(strings.NewReader("tail"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, conn.String(), "Content-Length: 18\r\n")
+	assert.True(t, strings.HasSuffix(conn.String(), "\r\n\r\n{\"answer\":42}\ntail"))
+	assert.Empty(t, conn.sources)
+
+	// Test: Out of order like any other body write
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.Finish())
+	_, err = w.ReadFrom(strings.NewReader("late"))
+	assert.ErrorIs(t, err, ErrWriteOrder)
+}
+
+func TestWriterReadFromTCP(t *testing.T) {
+	// Test: A file reaches the client over a real TCP connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	content := strings.Repeat("video bytes ", 100_000)
+	f := tempFile(t, content)
+	done := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		w := NewWriter(conn)
+		w.SetBufferSize(4096)
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			done <- err
+			return
+		}
+		if err := w.WriteHeaders(headers.NewHeaders()); err != nil {
+			done <- err
+			return
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			done <- err
+			return
+		}
+		done <- w.Finish()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	head, body, ok := strings.Cut(string(got), "\r\n\r\n")
+	require.True(t, ok)
+	assert.Contains(t, head, "Content-Length: 1200000")
+	assert.Equal(t, content, body)
+}
@@ -0,0 +1,65 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStatusLine(t *testing.T) {
+	tests := []struct {
+		code StatusCode
+		want string
+	}{
+		{StatusCreated, "HTTP/1.1 201 Created\r\n"},
+		{StatusNoContent, "HTTP/1.1 204 No Content\r\n"},
+		{StatusMovedPermanently, "HTTP/1.1 301 Moved Permanently\r\n"},
+		{StatusNotModified, "HTTP/1.1 304 Not Modified\r\n"},
+		{StatusNotFound, "HTTP/1.1 404 Not Found\r\n"},
+		{StatusMethodNotAllowed, "HTTP/1.1 405 Method Not Allowed\r\n"},
+		{StatusServiceUnavailable, "HTTP/1.1 503 Service Unavailable\r\n"},
+		// unregistered codes keep the space before the empty reason
+		{599, "HTTP/1.1 599 \r\n"},
+	}
+	for _, tc := range tests {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf)
+		require.NoError(t, w.WriteStatusLine(tc.code))
+		assert.Equal(t, tc.want, buf.String())
+	}
+
+	// Test: Custom reason phrase
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLineReason(StatusOK, "Totally Fine"))
+	assert.Equal(t, "HTTP/1.1 200 Totally Fine\r\n", buf.String())
+
+	// Test: Codes that aren't three digits and reasons that break the line
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	assert.ErrorIs(t, w.WriteStatusLine(99), ErrInvalidStatusCode)
+	assert.ErrorIs(t, w.WriteStatusLine(1000), ErrInvalidStatusCode)
+	assert.ErrorIs(t, w.WriteStatusLineReason(StatusOK, "OK\r\nX-Injected: 1"), ErrInvalidReason)
+	assert.Empty(t, buf.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestWriteStatusLineError(t *testing.T) {
+	// Test: Write errors reach the caller
+	w := NewWriter(failingWriter{})
+	assert.EqualError(t, w.WriteStatusLine(StatusOK), "connection reset")
+}
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "I'm a teapot", StatusText(StatusTeapot))
+	assert.Equal(t, "Unprocessable Content", StatusText(422))
+	assert.Empty(t, StatusText(299))
+}
@@ -1,31 +1,121 @@
 package server
 
 import (
-	"io"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
 
+	"github.com/seandisero/httpfromtcp/internal/negotiate"
 	"github.com/seandisero/httpfromtcp/internal/request"
 	"github.com/seandisero/httpfromtcp/internal/response"
 )
 
+// HandlerError is an error a handler returns to have the server answer with
+// StatusCode. Message is shown to the client, it defaults to the reason
+// phrase of the status.
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
 }
 
+func (he *HandlerError) Error() string {
+	return fmt.Sprintf("%d %s", he.StatusCode, he.message())
+}
+
+func (he *HandlerError) message() string {
+	if he.Message == "" {
+		return response.StatusText(he.StatusCode)
+	}
+	return he.Message
+}
+
 type Handler func(w *response.Writer, req *request.Request)
 
-func (he *HandlerError) Write(w io.ReadWriteCloser) {
-	// body := []byte(he.Message)
-	// h := response.GetDefaultHeaders(len(body))
-	// err := response.WriteStatusLine(w, he.StatusCode)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	// if err = response.WriteHeaders(w, h); err != nil {
-	// 	log.Fatal(err)
-	// }
-	// _, err = w.Write(body)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
+// ErrorHandler is a handler that can fail. A *HandlerError it returns, even
+// a wrapped one, picks the status of the error response, any other error
+// becomes a 500 without its text reaching the client.
+type ErrorHandler func(w *response.Writer, req *request.Request) error
+
+// HandleErrors turns h into a Handler that renders the errors h returns
+func HandleErrors(h ErrorHandler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		err := h(w, req)
+		if err == nil {
+			return
+		}
+		var he *HandlerError
+		var perr *request.ParseError
+		switch {
+		case errors.As(err, &he):
+		case errors.As(err, &perr):
+			// the request body was bad or too large, that's the client's error
+			he = &HandlerError{StatusCode: response.StatusCode(perr.StatusCode)}
+		default:
+			he = &HandlerError{StatusCode: response.StatusInternalServerError}
+		}
+		if err != error(he) {
+			// the context wrapped around the error is only for the log
+			log.Printf("error handling %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		}
+		if err := he.Write(w, req); err != nil {
+			log.Printf("error writing %q response: %v", he.Error(), err)
+		}
+	}
 }
+
+// Write sends the error as the response to req, as HTML, JSON or plain text
+// depending on what the request accepts. It fails if the handler already
+// started a response of its own.
+func (he *HandlerError) Write(w *response.Writer, req *request.Request) error {
+	if w.StatusWritten() {
+		return fmt.Errorf("%w: the handler already sent a status", response.ErrWriteOrder)
+	}
+
+	contentType, err := negotiate.ContentType(req.Headers, []string{"text/html", "application/json", "text/plain"})
+	if err != nil {
+		// an error page the client didn't ask for beats no error page
+		contentType = "text/plain"
+	}
+	var body []byte
+	switch contentType {
+	case "text/html":
+		title := html.EscapeString(fmt.Sprintf("%d %s", he.StatusCode, response.StatusText(he.StatusCode)))
+		body = fmt.Appendf(nil, htmlError, title, title, html.EscapeString(he.message()))
+	case "application/json":
+		body, err = json.Marshal(struct {
+			Status  response.StatusCode `json:"status"`
+			Error   string              `json:"error"`
+			Message string              `json:"message"`
+		}{he.StatusCode, response.StatusText(he.StatusCode), he.message()})
+		if err != nil {
+			return err
+		}
+	default:
+		body = fmt.Appendf(nil, "%s\n", he.Error())
+	}
+
+	h := response.GetDefaultHeaders(len(body))
+	h.Replace("Content-Type", contentType)
+	h.Set("Vary", "Accept")
+	if err := w.WriteStatusLine(he.StatusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
+
+const htmlError = `<html>
+  <head>
+    <title>%s</title>
+  </head>
+  <body>
+    <h1>%s</h1>
+    <p>%s</p>
+  </body>
+</html>
+`
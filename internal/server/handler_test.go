@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/seandisero/httpfromtcp/internal/request"
+	"github.com/seandisero/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveOnce runs h for a GET with the given Accept and returns the response
+func serveOnce(t *testing.T, accept string, h ErrorHandler) string {
+	t.Helper()
+	raw := "GET /thing HTTP/1.1\r\nHost: localhost\r\n"
+	if accept != "" {
+		raw += "Accept: " + accept + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	HandleErrors(h)(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func TestHandleErrors(t *testing.T) {
+	notFound := func(w *response.Writer, req *request.Request) error {
+		return fmt.Errorf("loading %s: %w", req.Path(), &HandlerError{StatusCode: response.StatusNotFound, Message: "no <thing> here"})
+	}
+
+	// Test: HTML for browsers, with the message escaped
+	resp := serveOnce(t, "text/html,application/xhtml+xml,*/*;q=0.8", notFound)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 Not Found\r\n"), resp)
+	assert.Contains(t, resp, "Content-Type: text/html\r\n")
+	assert.Contains(t, resp, "Vary: Accept\r\n")
+	assert.Contains(t, resp, "<title>404 Not Found</title>")
+	assert.Contains(t, resp, "<p>no &lt;thing&gt; here</p>")
+
+	// Test: JSON for API clients
+	resp = serveOnce(t, "application/json", notFound)
+	assert.Contains(t, resp, "Content-Type: application/json\r\n")
+	assert.True(t, strings.HasSuffix(resp, `{"status":404,"error":"Not Found","message":"no \u003cthing\u003e here"}`), resp)
+
+	// Test: Plain text otherwise, also when nothing offered is acceptable
+	resp = serveOnce(t, "text/plain", notFound)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n404 no <thing> here\n"), resp)
+	resp = serveOnce(t, "image/png", notFound)
+	assert.Contains(t, resp, "Content-Type: text/plain\r\n")
+
+	// Test: Other errors are a 500 that doesn't leak the error text
+	resp = serveOnce(t, "text/plain", func(w *response.Writer, req *request.Request) error {
+		return errors.New("database password is hunter2")
+	})
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 500 Internal Server Error\r\n"), resp)
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\n500 Internal Server Error\n"), resp)
+	assert.NotContains(t, resp, "hunter2")
+
+	// Test: An error after the handler answered doesn't add a second response
+	resp = serveOnce(t, "", func(w *response.Writer, req *request.Request) error {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(2))
+		w.WriteBody([]byte("ok"))
+		return &HandlerError{StatusCode: response.StatusServiceUnavailable}
+	})
+	assert.Equal(t, 1, strings.Count(resp, "HTTP/1.1"))
+	assert.NotContains(t, resp, "503")
+}
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
 	"sync/atomic"
+	"time"
 
 	"github.com/seandisero/httpfromtcp/internal/request"
 	"github.com/seandisero/httpfromtcp/internal/response"
@@ -14,10 +18,43 @@ import (
 type Server struct {
 	listener net.Listener
 	handler  Handler
+	config   Config
 	closed   atomic.Bool
 }
 
+// Config holds the tunables of a Server
+type Config struct {
+	// Limits bounds the size of the requests the server accepts
+	Limits request.Limits
+	// ResponseBufferSize is how much of a response body is buffered to send
+	// it with a Content-Length, larger bodies are sent chunked. 0 leaves the
+	// framing to the handler.
+	ResponseBufferSize int
+	// MaxRequestsPerConn closes a keep-alive connection after that many
+	// requests, 0 means no limit
+	MaxRequestsPerConn int
+	// ReadTimeout bounds reading a request, body included, from the moment
+	// it starts to arrive. A request that takes longer gets a 408. 0 means
+	// no limit.
+	ReadTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection waits for the next
+	// request before it is closed, 0 means ReadTimeout is used
+	IdleTimeout time.Duration
+}
+
+var DefaultConfig = Config{
+	Limits:             request.DefaultLimits,
+	ResponseBufferSize: 4096,
+	MaxRequestsPerConn: 1000,
+	ReadTimeout:        30 * time.Second,
+	IdleTimeout:        2 * time.Minute,
+}
+
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithConfig(port, handler, DefaultConfig)
+}
+
+func ServeWithConfig(port int, handler Handler, config Config) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
@@ -25,6 +62,7 @@ func Serve(port int, handler Handler) (*Server, error) {
 	svr := &Server{
 		listener: listener,
 		handler:  handler,
+		config:   config,
 	}
 	go svr.listen()
 	return svr, nil
@@ -56,16 +94,111 @@ func (s *Server) listen() {
 	}
 }
 
+// handle serves the requests on conn one after the other for as long as both
+// sides keep the connection open
 func (s *Server) handle(conn net.Conn) {
 	defer conn.Close()
 
-	responseWriter := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		responseWriter.WriteStatusLine(response.StatusBadRequest)
-		responseWriter.WriteHeaders(response.GetDefaultHeaders(0))
-		return
+	requests := request.NewReaderWithLimits(conn, s.config.Limits)
+	defer requests.Release()
+	for served := 1; ; served++ {
+		if served > 1 {
+			// between requests the connection is idle, the client may keep
+			// it open for a while but not forever
+			setReadTimeout(conn, s.config.IdleTimeout, s.config.ReadTimeout)
+			if err := requests.Wait(); err != nil {
+				return
+			}
+		}
+		setReadTimeout(conn, s.config.ReadTimeout)
+		req, err := requests.ReadRequest()
+		if errors.Is(err, io.EOF) {
+			// the client closed the connection between requests
+			return
+		}
+		if err != nil {
+			log.Printf("error parsing request from %s: %v", conn.RemoteAddr(), err)
+			writeParseError(response.NewWriter(conn), err)
+			return
+		}
+
+		last := s.config.MaxRequestsPerConn > 0 && served >= s.config.MaxRequestsPerConn
+		if !s.serve(conn, requests, req, !last) {
+			return
+		}
 	}
+}
+
+// serve answers req, it reports whether the connection can carry another
+// request afterwards
+func (s *Server) serve(conn net.Conn, requests *request.Reader, req *request.Request, keepAlive bool) bool {
+	responseWriter := response.NewWriter(conn)
+	responseWriter.SetVersion(req.RequestLine.HttpVersion)
+	responseWriter.SetHeadResponse(req.RequestLine.Method == "HEAD")
+	responseWriter.SetBufferSize(s.config.ResponseBufferSize)
+	responseWriter.SetKeepAlive(keepAlive && req.KeepAlive() && !s.closed.Load())
+	responseWriter.SetContinuePending(req.ExpectsContinue())
+	req.OnContinue(responseWriter.WriteContinue)
 
 	s.handler(responseWriter, req)
+
+	// the handler may have left some of the body unread, it has to go before
+	// the next request can be parsed. It is read before the response is
+	// finished so a body over the limit is still answered with a 413 when the
+	// handler sent nothing. A client still waiting for 100 Continue won't send
+	// it, the connection is done then.
+	bodyErr := requests.Discard()
+	var perr *request.ParseError
+	if errors.As(bodyErr, &perr) && !responseWriter.StatusWritten() {
+		log.Printf("error reading request body from %s: %v", conn.RemoteAddr(), bodyErr)
+		writeParseError(responseWriter, bodyErr)
+		return false
+	}
+	if bodyErr != nil {
+		responseWriter.SetKeepAlive(false)
+	}
+
+	if err := responseWriter.Finish(); err != nil {
+		log.Printf("error finishing response to %s: %v", conn.RemoteAddr(), err)
+		return false
+	}
+	return bodyErr == nil && responseWriter.KeepAlive()
+}
+
+// writeParseError answers a request that could not be read in full, the
+// connection is closed after it. The body only names the status, what the
+// parser choked on is for the log.
+func writeParseError(w *response.Writer, err error) {
+	status := parseErrorStatus(err)
+	body := []byte(response.StatusText(status) + "\n")
+	w.SetKeepAlive(false)
+	w.WriteStatusLine(status)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+	w.Finish()
+}
+
+// setReadTimeout sets the read deadline of conn to the first of timeouts that
+// isn't 0 from now, or clears it if they all are
+func setReadTimeout(conn net.Conn, timeouts ...time.Duration) {
+	var deadline time.Time
+	for _, timeout := range timeouts {
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+			break
+		}
+	}
+	conn.SetReadDeadline(deadline)
+}
+
+// parseErrorStatus picks the response status for a request that could not be parsed
+func parseErrorStatus(err error) response.StatusCode {
+	var perr *request.ParseError
+	if errors.As(err, &perr) {
+		return response.StatusCode(perr.StatusCode)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return response.StatusRequestTimeout
+	}
+	return response.StatusBadRequest
 }
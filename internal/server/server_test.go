@@ -0,0 +1,183 @@
+package server
+
+import (
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seandisero/httpfromtcp/internal/request"
+	"github.com/seandisero/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exchange sends raw to a Server with config and handler over an in-memory
+// connection, and returns everything written back until the server closed it
+func exchange(t *testing.T, config Config, handler Handler, raw string) string {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	s := &Server{handler: handler, config: config}
+	done := make(chan struct{})
+	go func() {
+		s.handle(serverConn)
+		close(done)
+	}()
+	go func() {
+		// net.Pipe doesn't buffer, the requests are written while the
+		// responses are read. The server may hang up before all of them.
+		io.WriteString(clientConn, raw)
+	}()
+	got, err := io.ReadAll(clientConn)
+	require.NoError(t, err)
+	<-done
+	return string(got)
+}
+
+func echoPath(w *response.Writer, req *request.Request) {
+	h := response.GetDefaultHeaders(0)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(req.Path()))
+}
+
+var statusLine = regexp.MustCompile(`HTTP/\d\.\d \d{3}[^\r]*`)
+
+// statusLines returns the status line of every response in raw, the bodies
+// in these tests don't end in a newline so the next response can follow on
+// the same line
+func statusLines(raw string) []string {
+	return statusLine.FindAllString(raw, -1)
+}
+
+func TestServerKeepAlive(t *testing.T) {
+	// Test: Requests are served until the client asks to close
+	got := exchange(t, DefaultConfig, echoPath,
+		"GET /one HTTP/1.1\r\nHost: a\r\n\r\n"+
+			"GET /two HTTP/1.1\r\nHost: a\r\n\r\n"+
+			"GET /three HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"+
+			"GET /never HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Len(t, statusLines(got), 3)
+	assert.Contains(t, got, "\r\n\r\n/one")
+	assert.Contains(t, got, "\r\n\r\n/two")
+	assert.True(t, strings.HasSuffix(got, "\r\n\r\n/three"))
+	assert.Equal(t, 1, strings.Count(got, "Connection: close\r\n"))
+	assert.NotContains(t, got, "/never")
+
+	// Test: HEAD responses have no body, the next request follows right away
+	got = exchange(t, DefaultConfig, echoPath,
+		"HEAD /one HTTP/1.1\r\nHost: a\r\n\r\n"+
+			"GET /two HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+	assert.Len(t, statusLines(got), 2)
+	assert.Contains(t, got, "Content-Length: 4\r\n")
+	assert.NotContains(t, got, "/one")
+	assert.True(t, strings.HasSuffix(got, "\r\n\r\n/two"))
+	assert.Equal(t, 1, strings.Count(got, "Connection: close\r\n"))
+
+	// Test: HTTP/1.0 only persists when asked to
+	got = exchange(t, DefaultConfig, echoPath,
+		"GET /one HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"+
+			"GET /two HTTP/1.0\r\n\r\n"+
+			"GET /never HTTP/1.0\r\n\r\n")
+	assert.Equal(t, []string{"HTTP/1.0 200 OK", "HTTP/1.0 200 OK"}, statusLines(got))
+	assert.Contains(t, got, "Connection: keep-alive\r\n")
+	assert.NotContains(t, got, "/never")
+
+	// Test: The request limit closes the connection
+	config := DefaultConfig
+	config.MaxRequestsPerConn = 2
+	got = exchange(t, config, echoPath, strings.Repeat("GET / HTTP/1.1\r\nHost: a\r\n\r\n", 3))
+	assert.Len(t, statusLines(got), 2)
+	assert.Equal(t, 1, strings.Count(got, "Connection: close\r\n"))
+
+	// Test: A body short of its Content-Length ends the connection
+	config.MaxRequestsPerConn = 0
+	config.ResponseBufferSize = 0
+	got = exchange(t, config, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(10))
+		w.WriteBody([]byte("abc"))
+	}, strings.Repeat("GET / HTTP/1.1\r\nHost: a\r\n\r\n", 2))
+	assert.Len(t, statusLines(got), 1)
+
+	// Test: A handler closing the connection is honored
+	got = exchange(t, DefaultConfig, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Set("Connection", "close")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+	}, strings.Repeat("GET / HTTP/1.1\r\nHost: a\r\n\r\n", 2))
+	assert.Len(t, statusLines(got), 1)
+
+	// Test: A parse error ends the connection
+	got = exchange(t, DefaultConfig, echoPath,
+		"GET /one HTTP/1.1\r\nHost: a\r\n\r\n"+
+			"GET /two HTTP/9.9\r\nHost: a\r\n\r\n"+
+			"GET /never HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, []string{"HTTP/1.1 200 OK", "HTTP/1.1 505 HTTP Version Not Supported"}, statusLines(got))
+	assert.True(t, strings.HasSuffix(got, "\r\n\r\nHTTP Version Not Supported\n"))
+	assert.NotContains(t, got, "offset")
+
+	// Test: A chunked HTTP/1.0 request can't smuggle a second one
+	got = exchange(t, DefaultConfig, echoPath,
+		"POST /one HTTP/1.0\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"+
+			"GET /smuggled HTTP/1.0\r\n\r\n")
+	assert.Equal(t, []string{"HTTP/1.1 400 Bad Request"}, statusLines(got))
+	assert.Contains(t, got, "Connection: close\r\n")
+	assert.NotContains(t, got, "/never")
+}
+
+func TestServerTimeouts(t *testing.T) {
+	config := DefaultConfig
+	config.ReadTimeout = 50 * time.Millisecond
+	config.IdleTimeout = 10 * time.Millisecond
+
+	// Test: An idle connection is closed after the idle timeout
+	got := exchange(t, config, echoPath, "GET /one HTTP/1.1\r\nHost: a\r\n\r\n")
+	assert.Equal(t, []string{"HTTP/1.1 200 OK"}, statusLines(got))
+
+	// Test: A request that stalls half way gets a 408
+	got = exchange(t, config, echoPath, "GET /one HTTP/1.1\r\nHost: a\r\n\r\nGET /two HTTP/1.1\r\nHo")
+	assert.Equal(t, []string{"HTTP/1.1 200 OK", "HTTP/1.1 408 Request Timeout"}, statusLines(got))
+	assert.Contains(t, got, "Connection: close\r\n")
+}
+
+func TestServerUnreadBody(t *testing.T) {
+	ignoreBody := func(w *response.Writer, req *request.Request) {
+		w.WriteBody([]byte(req.Path()))
+	}
+
+	// Test: Unread bodies are skipped to reach the next request
+	got := exchange(t, DefaultConfig, ignoreBody,
+		"POST /one HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello"+
+			"POST /two HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nworld\r\n0\r\n\r\n"+
+			"GET /three HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n")
+	assert.Len(t, statusLines(got), 3)
+	assert.True(t, strings.HasSuffix(got, "\r\n\r\n/three"))
+
+	// Test: A body held back for 100 Continue ends the connection instead
+	got = exchange(t, DefaultConfig, ignoreBody,
+		"POST /one HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+	assert.Equal(t, []string{"HTTP/1.1 200 OK"}, statusLines(got))
+	assert.NotContains(t, got, "100 Continue")
+	assert.Contains(t, got, "Connection: close\r\n")
+
+	// Test: A chunked body over the limit gets a 413 when the handler sent nothing
+	config := DefaultConfig
+	config.Limits.MaxBodySize = 4
+	tooLarge := "POST /one HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n" +
+		"GET /never HTTP/1.1\r\nHost: a\r\n\r\n"
+	got = exchange(t, config, func(w *response.Writer, req *request.Request) {}, tooLarge)
+	assert.Equal(t, []string{"HTTP/1.1 413 Content Too Large"}, statusLines(got))
+	assert.Contains(t, got, "Connection: close\r\n")
+
+	// Test: The same from a handler that read the body and returned the error
+	got = exchange(t, config, HandleErrors(func(w *response.Writer, req *request.Request) error {
+		_, err := io.ReadAll(req.Body)
+		return err
+	}), tooLarge)
+	assert.Equal(t, []string{"HTTP/1.1 413 Content Too Large"}, statusLines(got))
+	assert.Contains(t, got, "Connection: close\r\n")
+}